@@ -3,12 +3,12 @@ package jshapi
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"path"
 	"strings"
 
 	"goji.io"
-	"goji.io/pat"
 
 	"github.com/derekdowling/go-stdlogger"
 	"github.com/derekdowling/goji2-logger"
@@ -16,10 +16,25 @@ import (
 
 // API is used to direct HTTP requests to resources
 type API struct {
-	*goji.Mux
+	*http.ServeMux
 	prefix    string
 	Resources map[string]*Resource
 	Debug     bool
+	// Jobs runs background work for asynchronous resources, one is created
+	// automatically if needed
+	Jobs *JobQueue
+	// Errors translates errors returned by storage into JSON API errors
+	Errors *ErrorMapper
+	// Limits applies to every resource that doesn't set its own
+	Limits BodyLimits
+	// middleware wraps every request handled by the API
+	middleware []func(http.Handler) http.Handler
+	// handler is the ServeMux wrapped by middleware
+	handler http.Handler
+	// events streams the changes of every resource, see Events
+	events *ChangeFeed
+	// listeners are notified of changes to every resource, see Listen
+	listeners []ChangeListener
 }
 
 /*
@@ -39,10 +54,14 @@ func New(prefix string) *API {
 	}
 
 	// create our new API
+	mux := newServeMux()
+
 	return &API{
-		Mux:       goji.NewMux(),
+		ServeMux:  mux,
+		handler:   mux,
 		prefix:    prefix,
 		Resources: map[string]*Resource{},
+		Errors:    NewErrorMapper(),
 	}
 }
 
@@ -74,24 +93,77 @@ func Default(prefix string, debug bool, logger std.Logger) *API {
 	return api
 }
 
-// Add implements mux support for a given resource which is effectively handled as:
-// pat.New("/(prefix/)resource.Plu*)
+// ServeHTTP implements http.Handler, resolving the request's Route and passing it
+// through the API's middleware before routing it to a resource
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.handler.ServeHTTP(w, withRoute(r, a.route))
+}
+
+/*
+Use appends a standard net/http middleware to the API's middleware stack.
+Middleware are called in the order they are added, before the request is routed to
+a resource.
+*/
+func (a *API) Use(middleware func(http.Handler) http.Handler) {
+	a.middleware = append(a.middleware, middleware)
+	a.handler = chain(a.middleware, a.ServeMux)
+}
+
+// UseC appends context-aware goji middleware to the API's middleware stack, see
+// GojiMiddleware
+func (a *API) UseC(middleware func(goji.Handler) goji.Handler) {
+	a.Use(GojiMiddleware(middleware))
+}
+
+// Add implements mux support for a given resource which is effectively handled as
+// "/(prefix/)resource" and "/(prefix/)resource/"
 func (a *API) Add(resource *Resource) {
 
 	// track our associated resources, will enable auto-generation docs later
 	a.Resources[resource.Type] = resource
+	resource.errors = a.Errors
+	resource.resources = a.Resources
+	resource.apiLimits = &a.Limits
+	for _, listener := range a.listeners {
+		resource.Listen(listener)
+	}
 
-	// Because of how prefix matches work:
-	// https://godoc.org/github.com/goji/goji/pat#hdr-Prefix_Matches
-	// We need two separate routes,
+	// resources route using "/resources/..." paths, so strip the API prefix
+	var handler http.Handler = resource
+	if a.prefix != "/" {
+		handler = http.StripPrefix(a.prefix, resource)
+	}
+
+	// Because of how ServeMux patterns match, we need two separate routes,
 	// /(prefix/)resources
 	matcher := path.Join(a.prefix, resource.Type)
-	a.Mux.HandleC(pat.New(matcher), resource)
+	a.ServeMux.Handle(matcher, handler)
 
 	// And:
-	// /(prefix/)resources/*
-	idMatcher := path.Join(a.prefix, resource.Type, "*")
-	a.Mux.HandleC(pat.New(idMatcher), resource)
+	// /(prefix/)resources/
+	a.ServeMux.Handle(matcher+"/", handler)
+
+	if resource.async {
+		a.addJobs(resource)
+	}
+}
+
+// addJobs gives an asynchronous resource access to the API's JobQueue, and
+// registers the `GET /jobs/:id` status resource the first time it is needed
+func (a *API) addJobs(resource *Resource) {
+	if a.Jobs == nil {
+		a.Jobs = NewJobQueue(DefaultJobWorkers, DefaultJobBacklog)
+	}
+
+	resource.jobs = a.Jobs
+	if a.Jobs.errors == nil {
+		a.Jobs.errors = a.Errors
+	}
+
+	if _, registered := a.Resources[JobType]; !registered {
+		a.Jobs.prefix = a.prefix
+		a.Add(NewJobResource(a.Jobs))
+	}
 }
 
 // RouteTree prints out all accepted routes for the API that use jshapi implemented
@@ -1,10 +1,19 @@
+// Resources route using Go 1.22 ServeMux patterns, which GOPATH builds disable by
+// default
+//go:debug httpmuxgo121=0
+
 package jshapi
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"goji.io"
+
+	xcontext "golang.org/x/net/context"
+
 	"github.com/derekdowling/go-json-spec-handler"
 	"github.com/derekdowling/go-json-spec-handler/client"
 	. "github.com/smartystreets/goconvey/convey"
@@ -51,3 +60,51 @@ func TestAPI(t *testing.T) {
 		})
 	})
 }
+
+type testContextKey string
+
+func TestMiddleware(t *testing.T) {
+
+	Convey("Middleware Tests", t, func() {
+
+		api := New("api")
+		resource := NewResource(testResourceType)
+
+		var seen interface{}
+		resource.HandleFunc("GET /bars/seen", func(w http.ResponseWriter, r *http.Request) {
+			seen = r.Context().Value(testContextKey("goji"))
+			w.WriteHeader(http.StatusOK)
+		})
+		api.Add(resource)
+
+		Convey("->Use()", func() {
+			api.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("X-Middleware", "std")
+					next.ServeHTTP(w, r)
+				})
+			})
+
+			recorder := httptest.NewRecorder()
+			api.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/bars/seen", nil))
+
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			So(recorder.Header().Get("X-Middleware"), ShouldEqual, "std")
+		})
+
+		Convey("->UseC()", func() {
+			resource.UseC(func(next goji.Handler) goji.Handler {
+				return goji.HandlerFunc(func(ctx xcontext.Context, w http.ResponseWriter, r *http.Request) {
+					ctx = context.WithValue(ctx, testContextKey("goji"), "adapted")
+					next.ServeHTTPC(ctx, w, r)
+				})
+			})
+
+			recorder := httptest.NewRecorder()
+			api.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/bars/seen", nil))
+
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			So(seen, ShouldEqual, "adapted")
+		})
+	})
+}
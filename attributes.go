@@ -0,0 +1,185 @@
+package jshapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+/*
+AttributePermission decides whether a caller may read or write an attribute of
+object. The principal is nil for anonymous requests.
+*/
+type AttributePermission func(ctx context.Context, principal *Principal, object *jsh.Object) bool
+
+/*
+AttributePolicy protects a single attribute of a resource's objects:
+
+	users.AttributePolicy("salary", jshapi.AttributePolicy{
+		Read:  isAdmin,
+		Write: isAdmin,
+	})
+	users.AttributePolicy("email", jshapi.AttributePolicy{Immutable: true})
+*/
+type AttributePolicy struct {
+	// Read hides the attribute from responses when it returns false, a nil Read
+	// lets everyone see the attribute
+	Read AttributePermission
+	// Write rejects POST and PATCH requests setting the attribute with a 403 when
+	// it returns false, a nil Write lets everyone set the attribute
+	Write AttributePermission
+	// Immutable rejects PATCH requests setting the attribute with a 422, it can
+	// still be set by POST
+	Immutable bool
+}
+
+// AttributePolicy protects the named attribute of the resource's objects
+func (res *Resource) AttributePolicy(attribute string, policy AttributePolicy) {
+	res.attributes[attribute] = policy
+}
+
+// send redacts objects by the attribute policies of the resource serving their
+// type before sending a successful response
+func (res *Resource) send(w http.ResponseWriter, r *http.Request, sendable jsh.Sendable) {
+	ctx, span := res.startSpan(r.Context(), "jshapi.serialize", "")
+	defer span.End()
+
+	switch typed := sendable.(type) {
+	case *jsh.Object:
+		if typed != nil {
+			span.SetAttribute(AttrResourceID, typed.ID)
+		}
+		sendable = res.redact(ctx, typed)
+	case jsh.List:
+		redacted := make(jsh.List, 0, len(typed))
+		for _, object := range typed {
+			redacted = append(redacted, res.redact(ctx, object))
+		}
+		sendable = redacted
+	case *jsh.Document:
+		for index, object := range typed.Data {
+			typed.Data[index] = res.redact(ctx, object)
+		}
+		for index, object := range typed.Included {
+			typed.Included[index] = res.redact(ctx, object)
+		}
+	}
+
+	SendHandler(ctx, w, r, sendable)
+}
+
+// redact returns a copy of object without the attributes the caller may not read.
+// The original object is left untouched as storage may still be holding on to it.
+// Objects of other types are redacted by the API resource serving them, if any.
+func (res *Resource) redact(ctx context.Context, object *jsh.Object) *jsh.Object {
+	if object == nil {
+		return object
+	}
+
+	if object.Type != res.Type {
+		related, exists := res.resources[object.Type]
+		if !exists || related.Type != object.Type {
+			return object
+		}
+
+		return related.redact(ctx, object)
+	}
+
+	if len(res.attributes) == 0 {
+		return object
+	}
+
+	attributes, isMap := objectAttributes(object)
+	if !isMap {
+		return object
+	}
+
+	principal, _ := PrincipalFromContext(ctx)
+
+	removed := false
+	for name := range attributes {
+		policy, exists := res.attributes[name]
+		if exists && policy.Read != nil && !policy.Read(ctx, principal, object) {
+			delete(attributes, name)
+			removed = true
+		}
+	}
+
+	if !removed {
+		return object
+	}
+
+	raw, err := json.Marshal(attributes)
+	if err != nil {
+		return object
+	}
+
+	redacted := *object
+	redacted.Attributes = raw
+	return &redacted
+}
+
+// attributeWrites checks every attribute set by an incoming object against the
+// resource's attribute policies, returning an error for each one that is not
+// allowed
+func (res *Resource) attributeWrites(ctx context.Context, operation Operation, object *jsh.Object) jsh.ErrorType {
+	if len(res.attributes) == 0 {
+		return nil
+	}
+
+	attributes, isMap := objectAttributes(object)
+	if !isMap {
+		return nil
+	}
+
+	names := []string{}
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	principal, _ := PrincipalFromContext(ctx)
+
+	errors := jsh.ErrorList{}
+	for _, name := range names {
+		policy, exists := res.attributes[name]
+		if !exists {
+			continue
+		}
+
+		if policy.Immutable && operation == OpPatch {
+			immutableErr := jsh.InputError(fmt.Sprintf("'%s' can not be changed", name), name)
+			immutableErr.Title = "Immutable Attribute"
+			immutableErr.Source.Pointer = fmt.Sprintf("/data/attributes/%s", name)
+			errors = append(errors, immutableErr)
+			continue
+		}
+
+		if policy.Write != nil && !policy.Write(ctx, principal, object) {
+			writeErr := Forbidden(fmt.Sprintf("Not allowed to set '%s'", name))
+			writeErr.Source.Pointer = fmt.Sprintf("/data/attributes/%s", name)
+			errors = append(errors, writeErr)
+		}
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+
+	return errors
+}
+
+// objectAttributes decodes an object's attributes by name
+func objectAttributes(object *jsh.Object) (map[string]json.RawMessage, bool) {
+	attributes := map[string]json.RawMessage{}
+	if len(object.Attributes) == 0 {
+		return attributes, true
+	}
+
+	err := json.Unmarshal(object.Attributes, &attributes)
+	return attributes, err == nil
+}
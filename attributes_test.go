@@ -0,0 +1,109 @@
+package jshapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAttributePolicies(t *testing.T) {
+
+	attrs := map[string]string{
+		"name":   "bar",
+		"email":  "bar@example.com",
+		"salary": "100",
+	}
+
+	isAdmin := func(ctx context.Context, principal *Principal, object *jsh.Object) bool {
+		return principal != nil && principal.ID == "admin"
+	}
+
+	resource := NewMockResource(testResourceType, 2, attrs)
+	resource.AttributePolicy("salary", AttributePolicy{Read: isAdmin, Write: isAdmin})
+	resource.AttributePolicy("email", AttributePolicy{Immutable: true})
+
+	api := New("")
+	api.Use(testPrincipal)
+	api.Add(resource)
+
+	server := httptest.NewServer(api)
+	baseURL := server.URL
+
+	Convey("Attribute Policy Tests", t, func() {
+
+		Convey("should redact objects", func() {
+			doc, resp, err := jsc.Fetch(baseURL, testResourceType, "1")
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(string(doc.Data[0].Attributes), ShouldContainSubstring, "email")
+			So(string(doc.Data[0].Attributes), ShouldNotContainSubstring, "salary")
+		})
+
+		Convey("should redact lists", func() {
+			doc, _, err := jsc.List(baseURL, testResourceType)
+
+			So(err, ShouldBeNil)
+			So(len(doc.Data), ShouldEqual, 2)
+			So(string(doc.Data[1].Attributes), ShouldNotContainSubstring, "salary")
+		})
+
+		Convey("should show attributes to permitted callers", func() {
+			request, err := jsc.FetchRequest(baseURL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			request.Header.Set("X-User", "admin")
+
+			doc, _, err := jsc.Do(request, jsh.ObjectMode)
+			So(err, ShouldBeNil)
+			So(string(doc.Data[0].Attributes), ShouldContainSubstring, "salary")
+		})
+
+		Convey("should reject protected writes with a 403", func() {
+			object := sampleObject("", testResourceType, map[string]string{"salary": "200"})
+			doc, resp, err := jsc.Post(baseURL, object)
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			So(doc.Errors[0].Source.Pointer, ShouldEqual, "/data/attributes/salary")
+		})
+
+		Convey("should reject immutable writes with a 422", func() {
+			object := sampleObject("1", testResourceType, map[string]string{"email": "new@example.com"})
+			doc, resp, err := jsc.Patch(baseURL, object)
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+			So(doc.Errors[0].Source.Pointer, ShouldEqual, "/data/attributes/email")
+		})
+
+		Convey("should redact objects of other resources", func() {
+			posts := NewResource("posts")
+			posts.ToOne(testResourceType, func(ctx context.Context, id string) (*jsh.Object, error) {
+				return sampleObject("1", testResourceType, attrs), nil
+			})
+			api.Add(posts)
+
+			request, err := http.NewRequest(http.MethodGet, baseURL+"/posts/1/"+toOneName(testResourceType), nil)
+			So(err, ShouldBeNil)
+
+			doc, resp, err := jsc.Do(request, jsh.ObjectMode)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(string(doc.Data[0].Attributes), ShouldContainSubstring, "email")
+			So(string(doc.Data[0].Attributes), ShouldNotContainSubstring, "salary")
+		})
+
+		Convey("should allow immutable attributes on create", func() {
+			object := sampleObject("", testResourceType, map[string]string{"email": "new@example.com"})
+			_, resp, err := jsc.Post(baseURL, object)
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+		})
+	})
+}
@@ -0,0 +1,225 @@
+package jshapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+// AuditResourceType is the type of the objects served by NewAuditResource
+const AuditResourceType = "audit"
+
+/*
+AuditEntry records a single change to an object. Before is empty for new objects,
+and After is empty for deleted ones.
+*/
+type AuditEntry struct {
+	ID           string      `json:"id,omitempty"`
+	Time         time.Time   `json:"time"`
+	Principal    string      `json:"principal,omitempty"`
+	RequestID    string      `json:"requestId,omitempty"`
+	ResourceType string      `json:"resourceType"`
+	ResourceID   string      `json:"resourceId"`
+	Operation    Operation   `json:"operation"`
+	Before       *jsh.Object `json:"before,omitempty"`
+	After        *jsh.Object `json:"after,omitempty"`
+}
+
+// AuditSink stores audit entries
+type AuditSink interface {
+	Record(ctx context.Context, entry *AuditEntry) error
+}
+
+// AuditReader reads back stored audit entries, oldest first
+type AuditReader interface {
+	Entries(ctx context.Context) ([]*AuditEntry, error)
+}
+
+// MemoryAuditSink keeps audit entries in memory, for tests and development
+type MemoryAuditSink struct {
+	mutex   sync.RWMutex
+	entries []*AuditEntry
+}
+
+// NewMemoryAuditSink creates an empty MemoryAuditSink
+func NewMemoryAuditSink() *MemoryAuditSink {
+	return &MemoryAuditSink{}
+}
+
+// Record implements AuditSink
+func (m *MemoryAuditSink) Record(ctx context.Context, entry *AuditEntry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+// Entries implements AuditReader
+func (m *MemoryAuditSink) Entries(ctx context.Context) ([]*AuditEntry, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	entries := make([]*AuditEntry, len(m.entries))
+	copy(entries, m.entries)
+	return entries, nil
+}
+
+// FileAuditSink appends audit entries to a file as JSON lines
+type FileAuditSink struct {
+	mutex sync.Mutex
+	path  string
+	file  *os.File
+}
+
+// NewFileAuditSink opens, or creates, the file at path for appending entries
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuditSink{
+		path: path,
+		file: file,
+	}, nil
+}
+
+// Record implements AuditSink, syncing each entry to disk before returning
+func (f *FileAuditSink) Record(ctx context.Context, entry *AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	_, err = f.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	return f.file.Sync()
+}
+
+// Entries implements AuditReader by reading the file back
+func (f *FileAuditSink) Entries(ctx context.Context) ([]*AuditEntry, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []*AuditEntry{}
+	decoder := json.NewDecoder(file)
+
+	for {
+		entry := &AuditEntry{}
+		err = decoder.Decode(entry)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+}
+
+// Close closes the underlying file
+func (f *FileAuditSink) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.file.Close()
+}
+
+/*
+Audit records every change made to the resource's objects, along with who made it,
+in sink:
+
+	sink, err := jshapi.NewFileAuditSink("/var/log/api/audit.jsonl")
+	...
+	resource.Audit(sink)
+
+Entries are recorded for POST, PATCH, DELETE, their bulk and asynchronous
+variants, and actions. Objects are snapshotted before they change using the
+resource's Get storage, so Before is only set if Get is registered. Entries are
+recorded before the response is sent, and a failure to record one is sent as an
+ISE.
+*/
+func (res *Resource) Audit(sink AuditSink) {
+	res.auditSink = sink
+}
+
+/*
+NewAuditResource creates a read-only resource serving the entries of an audit
+log, which can be added to an API alongside the audited resources:
+
+	api.Add(jshapi.NewAuditResource(sink))
+
+Entries are served at GET /audit and GET /audit/:id, and should usually be
+protected with an Authorize policy.
+*/
+func NewAuditResource(reader AuditReader) *Resource {
+	resource := NewResource(AuditResourceType)
+
+	resource.List(func(ctx context.Context) (jsh.List, error) {
+		entries, err := reader.Entries(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		list := jsh.List{}
+		for _, entry := range entries {
+			object, objectErr := auditObject(entry)
+			if objectErr != nil {
+				return nil, objectErr
+			}
+
+			list = append(list, object)
+		}
+
+		return list, nil
+	})
+
+	resource.Get(func(ctx context.Context, id string) (*jsh.Object, error) {
+		entries, err := reader.Entries(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.ID == id {
+				return auditObject(entry)
+			}
+		}
+
+		return nil, jsh.NotFound(AuditResourceType, id)
+	})
+
+	return resource
+}
+
+// auditObject presents an audit entry as a JSON API object
+func auditObject(entry *AuditEntry) (*jsh.Object, error) {
+	attributes := *entry
+	attributes.ID = ""
+
+	object, err := jsh.NewObject(entry.ID, AuditResourceType, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	return object, nil
+}
@@ -0,0 +1,165 @@
+package jshapi
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	"github.com/derekdowling/jsh-api/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAudit(t *testing.T) {
+
+	Convey("Audit Tests", t, func() {
+
+		sink := NewMemoryAuditSink()
+
+		resource := NewCRUDResource(testResourceType, store.NewMemory(testResourceType))
+		resource.Audit(sink)
+
+		api := New("")
+		api.Use(testPrincipal)
+		api.Add(resource)
+		api.Add(NewAuditResource(sink))
+
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		do := func(request *http.Request) *http.Response {
+			request.Header.Set("X-User", "bob")
+
+			resp, err := http.DefaultClient.Do(request)
+			So(err, ShouldBeNil)
+			return resp
+		}
+
+		request, err := jsc.PostRequest(server.URL, sampleObject("", testResourceType, map[string]string{"foo": "bar"}))
+		So(err, ShouldBeNil)
+		So(do(request).StatusCode, ShouldEqual, http.StatusCreated)
+
+		Convey("should record new objects", func() {
+			entries, err := sink.Entries(context.Background())
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+
+			entry := entries[0]
+			So(entry.Principal, ShouldEqual, "bob")
+			So(entry.Operation, ShouldEqual, OpPost)
+			So(entry.ResourceType, ShouldEqual, testResourceType)
+			So(entry.ResourceID, ShouldEqual, "1")
+			So(entry.Before, ShouldBeNil)
+			So(entry.After.ID, ShouldEqual, "1")
+		})
+
+		Convey("should snapshot objects before they change", func() {
+			request, err := jsc.PatchRequest(server.URL, sampleObject("1", testResourceType, map[string]string{"foo": "baz"}))
+			So(err, ShouldBeNil)
+			So(do(request).StatusCode, ShouldEqual, http.StatusOK)
+
+			request, err = jsc.DeleteRequest(server.URL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			So(do(request).StatusCode, ShouldEqual, http.StatusNoContent)
+
+			entries, err := sink.Entries(context.Background())
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 3)
+
+			patched := entries[1]
+			So(patched.Operation, ShouldEqual, OpPatch)
+			So(string(patched.Before.Attributes), ShouldContainSubstring, `"bar"`)
+			So(string(patched.After.Attributes), ShouldContainSubstring, `"baz"`)
+
+			deleted := entries[2]
+			So(deleted.Operation, ShouldEqual, OpDelete)
+			So(deleted.ResourceID, ShouldEqual, "1")
+			So(string(deleted.Before.Attributes), ShouldContainSubstring, `"baz"`)
+			So(deleted.After, ShouldBeNil)
+		})
+
+		Convey("should not record failed mutations", func() {
+			request, err := jsc.DeleteRequest(server.URL, testResourceType, "404")
+			So(err, ShouldBeNil)
+			So(do(request).StatusCode, ShouldEqual, http.StatusNotFound)
+
+			entries, err := sink.Entries(context.Background())
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+		})
+
+		Convey("should record asynchronous jobs that succeed with a nil error", func() {
+			mutation := resource.observeMutation(context.Background(), OpPost)
+			job := mutation.job(func(ctx context.Context) (*jsh.Object, error) {
+				var err *jsh.Error
+				return sampleObject("2", testResourceType, testObjAttrs), err
+			})
+
+			_, err := job(context.Background())
+			So(normalizeError(err), ShouldBeNil)
+
+			entries, err := sink.Entries(context.Background())
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 2)
+			So(entries[1].ResourceID, ShouldEqual, "2")
+		})
+
+		Convey("should serve entries via the audit resource", func() {
+			doc, resp, err := jsc.List(server.URL, AuditResourceType)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(doc.Data, ShouldHaveLength, 1)
+
+			entry := &AuditEntry{}
+			So(doc.Data[0].Unmarshal(AuditResourceType, entry), ShouldBeNil)
+			So(entry.Principal, ShouldEqual, "bob")
+
+			doc, resp, err = jsc.Fetch(server.URL, AuditResourceType, doc.Data[0].ID)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			request, err := jsc.DeleteRequest(server.URL, AuditResourceType, doc.Data[0].ID)
+			So(err, ShouldBeNil)
+			So(do(request).StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
+
+func TestFileAuditSink(t *testing.T) {
+
+	Convey("FileAuditSink Tests", t, func() {
+
+		dir, err := ioutil.TempDir("", "audit")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "audit.jsonl")
+		sink, err := NewFileAuditSink(path)
+		So(err, ShouldBeNil)
+
+		after := sampleObject("1", testResourceType, testObjAttrs)
+		So(sink.Record(context.Background(), &AuditEntry{ID: "a", ResourceID: "1", Operation: OpPost, After: after}), ShouldBeNil)
+		So(sink.Record(context.Background(), &AuditEntry{ID: "b", ResourceID: "1", Operation: OpDelete, Before: after}), ShouldBeNil)
+		So(sink.Close(), ShouldBeNil)
+
+		Convey("should write one entry per line", func() {
+			contents, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(contents), ShouldStartWith, `{"id":"a"`)
+		})
+
+		Convey("should read entries back", func() {
+			entries, err := sink.Entries(context.Background())
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 2)
+			So(entries[1].Operation, ShouldEqual, OpDelete)
+			So(entries[1].Before.ID, ShouldEqual, "1")
+
+		})
+	})
+}
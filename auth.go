@@ -0,0 +1,356 @@
+package jshapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+// contextKey namespaces the values jshapi stores within a request's context
+type contextKey string
+
+const principalKey = contextKey("principal")
+
+var (
+	// ErrInvalidCredentials is returned by a Verifier when credentials are wrong
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrTokenExpired is returned by a Verifier when a token is no longer valid
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// ID uniquely identifies the caller, i.e. a user or API client id
+	ID string `json:"sub"`
+	// Roles granted to the caller
+	Roles []string `json:"roles,omitempty"`
+	// Claims holds any other verified information about the caller
+	Claims map[string]string `json:"claims,omitempty"`
+}
+
+// HasRole checks whether the principal has been granted a role
+func (p *Principal) HasRole(role string) bool {
+	for _, granted := range p.Roles {
+		if granted == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithPrincipal stores a principal within a context
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+/*
+PrincipalFromContext retrieves the caller authenticated by an Authenticator. This
+is the intended way for storage functions to find out who is making a request:
+
+	func Save(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+		principal, ok := jshapi.PrincipalFromContext(ctx)
+		...
+	}
+*/
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// Credentials are the unverified credentials presented with a request
+type Credentials struct {
+	// Scheme is the authentication scheme used, i.e. "Bearer", "Basic", or "APIKey"
+	Scheme   string
+	Token    string
+	Username string
+	Password string
+}
+
+/*
+CredentialExtractor finds credentials within a request, returning nil if the
+request doesn't carry any credentials it understands.
+*/
+type CredentialExtractor func(r *http.Request) *Credentials
+
+// BearerToken extracts an "Authorization: Bearer <token>" header
+func BearerToken(r *http.Request) *Credentials {
+	scheme, token := authorization(r)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil
+	}
+
+	return &Credentials{Scheme: "Bearer", Token: token}
+}
+
+// BasicAuth extracts an "Authorization: Basic <base64(user:pass)>" header
+func BasicAuth(r *http.Request) *Credentials {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+
+	return &Credentials{Scheme: "Basic", Username: username, Password: password}
+}
+
+// APIKeyHeader extracts an API key sent via the named header, i.e. "X-API-Key"
+func APIKeyHeader(header string) CredentialExtractor {
+	return func(r *http.Request) *Credentials {
+		key := r.Header.Get(header)
+		if key == "" {
+			return nil
+		}
+
+		return &Credentials{Scheme: "APIKey", Token: key}
+	}
+}
+
+// APIKeyQuery extracts an API key sent via the named query parameter, i.e.
+// "?api_key=<key>"
+func APIKeyQuery(param string) CredentialExtractor {
+	return func(r *http.Request) *Credentials {
+		key := r.URL.Query().Get(param)
+		if key == "" {
+			return nil
+		}
+
+		return &Credentials{Scheme: "APIKey", Token: key}
+	}
+}
+
+// authorization splits the Authorization header into its scheme and parameters
+func authorization(r *http.Request) (string, string) {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+
+	return parts[0], strings.TrimSpace(parts[1])
+}
+
+/*
+Verifier checks credentials and identifies the caller presenting them. Returning
+ErrInvalidCredentials or ErrTokenExpired results in a 401, a jsh.ErrorType is sent
+as is, and any other error is sent as an ISE.
+*/
+type Verifier interface {
+	Verify(ctx context.Context, credentials *Credentials) (*Principal, error)
+}
+
+// VerifierFunc allows a plain function to be used as a Verifier
+type VerifierFunc func(ctx context.Context, credentials *Credentials) (*Principal, error)
+
+// Verify implements Verifier
+func (f VerifierFunc) Verify(ctx context.Context, credentials *Credentials) (*Principal, error) {
+	return f(ctx, credentials)
+}
+
+/*
+Authenticator is a middleware that identifies the caller of every request it
+handles, and rejects requests with a 401 if it can't:
+
+	auth := jshapi.NewAuthenticator(
+		jshapi.NewHMACVerifier(secret),
+		jshapi.BearerToken,
+	)
+	api.Use(auth.Middleware)
+
+Extractors are tried in order, and the first one to find credentials wins.
+*/
+type Authenticator struct {
+	Verifier   Verifier
+	Extractors []CredentialExtractor
+	// Scheme and Realm are sent within the WWW-Authenticate header of 401
+	// responses. Scheme is only used for requests without credentials, others
+	// are challenged using the scheme of the credentials they presented.
+	Scheme string
+	Realm  string
+	// Optional lets requests without any credentials through without a
+	// principal, requests with bad credentials are still rejected
+	Optional bool
+}
+
+// NewAuthenticator creates an Authenticator, defaulting to BearerToken if no
+// extractors are provided
+func NewAuthenticator(verifier Verifier, extractors ...CredentialExtractor) *Authenticator {
+	if len(extractors) == 0 {
+		extractors = []CredentialExtractor{BearerToken}
+	}
+
+	return &Authenticator{
+		Verifier:   verifier,
+		Extractors: extractors,
+		Scheme:     "Bearer",
+		Realm:      "jshapi",
+	}
+}
+
+// Middleware can be registered via API.Use() or Resource.Use()
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		credentials := a.extract(r)
+		if credentials == nil {
+			if a.Optional {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			a.unauthorized(w, r, nil, "Missing authentication credentials")
+			return
+		}
+
+		principal, err := a.Verifier.Verify(ctx, credentials)
+		err = normalizeError(err)
+
+		switch {
+		case err == nil && principal == nil:
+			a.unauthorized(w, r, credentials, ErrInvalidCredentials.Error())
+		case err == nil:
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(ctx, principal)))
+		case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrTokenExpired):
+			a.unauthorized(w, r, credentials, err.Error())
+		default:
+			sendableErr, isType := err.(jsh.ErrorType)
+			if !isType {
+				sendableErr = jsh.ISE(fmt.Sprintf("Error verifying credentials: %s", err.Error()))
+			}
+
+			if sendableErr.StatusCode() == http.StatusUnauthorized {
+				a.challenge(w, credentials)
+			}
+
+			SendHandler(ctx, w, r, sendableErr)
+		}
+	})
+}
+
+// extract returns the credentials found by the first matching extractor
+func (a *Authenticator) extract(r *http.Request) *Credentials {
+	for _, extractor := range a.Extractors {
+		credentials := extractor(r)
+		if credentials != nil {
+			return credentials
+		}
+	}
+
+	return nil
+}
+
+// unauthorized sends a 401 along with an authentication challenge
+func (a *Authenticator) unauthorized(w http.ResponseWriter, r *http.Request, credentials *Credentials, detail string) {
+	a.challenge(w, credentials)
+	SendHandler(r.Context(), w, r, Unauthorized(detail))
+}
+
+// challenge sets the WWW-Authenticate header for the scheme of the credentials
+// presented, if any
+func (a *Authenticator) challenge(w http.ResponseWriter, credentials *Credentials) {
+	scheme := a.Scheme
+	if credentials != nil && credentials.Scheme != "" {
+		scheme = credentials.Scheme
+	}
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`%s realm="%s"`, scheme, a.Realm))
+}
+
+// Unauthorized creates a 401 error for requests that failed authentication
+func Unauthorized(detail string) *jsh.Error {
+	return &jsh.Error{
+		Title:  "Unauthorized",
+		Detail: detail,
+		Status: http.StatusUnauthorized,
+	}
+}
+
+/*
+HMACVerifier verifies bearer tokens created by SignToken without needing to talk to
+any other service. Tokens are a base64 encoded Principal and expiry, signed using
+HMAC-SHA256 with a shared secret.
+*/
+type HMACVerifier struct {
+	secret []byte
+	// Now returns the current time when checking for expiry, overridable for tests
+	Now func() time.Time
+}
+
+// NewHMACVerifier creates a verifier for tokens signed with secret
+func NewHMACVerifier(secret []byte) *HMACVerifier {
+	return &HMACVerifier{
+		secret: secret,
+		Now:    time.Now,
+	}
+}
+
+// tokenClaims is the signed payload of an HMAC token
+type tokenClaims struct {
+	Principal
+	Expires int64 `json:"exp"`
+}
+
+// SignToken creates a token for principal that HMACVerifier accepts until expires
+func SignToken(secret []byte, principal *Principal, expires time.Time) (string, error) {
+	payload, err := json.Marshal(tokenClaims{
+		Principal: *principal,
+		Expires:   expires.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + sign(secret, encoded), nil
+}
+
+// Verify implements Verifier
+func (v *HMACVerifier) Verify(ctx context.Context, credentials *Credentials) (*Principal, error) {
+	if credentials.Scheme != "Bearer" {
+		return nil, ErrInvalidCredentials
+	}
+
+	parts := strings.Split(credentials.Token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCredentials
+	}
+
+	expected := sign(v.secret, parts[0])
+	if !hmac.Equal([]byte(expected), []byte(parts[1])) {
+		return nil, ErrInvalidCredentials
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	claims := &tokenClaims{}
+	err = json.Unmarshal(payload, claims)
+	if err != nil || claims.ID == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	if v.Now().Unix() >= claims.Expires {
+		return nil, ErrTokenExpired
+	}
+
+	return &claims.Principal, nil
+}
+
+// sign computes the base64 encoded HMAC-SHA256 signature of payload
+func sign(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,137 @@
+package jshapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testSecret = []byte("secret")
+
+func TestAuthenticator(t *testing.T) {
+
+	var caller string
+
+	resource := NewResource(testResourceType)
+	resource.Get(func(ctx context.Context, id string) (*jsh.Object, error) {
+		principal, _ := PrincipalFromContext(ctx)
+		caller = principal.ID
+		return sampleObject(id, testResourceType, testObjAttrs), nil
+	})
+
+	auth := NewAuthenticator(NewHMACVerifier(testSecret), BearerToken, BasicAuth)
+
+	api := New("api")
+	api.Use(auth.Middleware)
+	api.Add(resource)
+
+	server := httptest.NewServer(api)
+	baseURL := server.URL + api.prefix
+
+	Convey("Authenticator Tests", t, func() {
+
+		request, err := jsc.FetchRequest(baseURL, testResourceType, "1")
+		So(err, ShouldBeNil)
+
+		Convey("should reject requests without credentials", func() {
+			resp, err := http.DefaultClient.Do(request)
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+			So(resp.Header.Get("WWW-Authenticate"), ShouldEqual, `Bearer realm="jshapi"`)
+		})
+
+		Convey("should reject tampered tokens", func() {
+			token, err := SignToken([]byte("wrong"), &Principal{ID: "1"}, time.Now().Add(time.Hour))
+			So(err, ShouldBeNil)
+
+			request.Header.Set("Authorization", "Bearer "+token)
+			resp, err := http.DefaultClient.Do(request)
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("should challenge using the scheme of the credentials presented", func() {
+			request.SetBasicAuth("user", "pass")
+			resp, err := http.DefaultClient.Do(request)
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+			So(resp.Header.Get("WWW-Authenticate"), ShouldEqual, `Basic realm="jshapi"`)
+		})
+
+		Convey("should pass the principal to storage", func() {
+			token, err := SignToken(testSecret, &Principal{ID: "42"}, time.Now().Add(time.Hour))
+			So(err, ShouldBeNil)
+
+			request.Header.Set("Authorization", "Bearer "+token)
+			resp, err := http.DefaultClient.Do(request)
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(caller, ShouldEqual, "42")
+		})
+	})
+}
+
+func TestHMACVerifier(t *testing.T) {
+
+	Convey("HMACVerifier Tests", t, func() {
+
+		verifier := NewHMACVerifier(testSecret)
+		principal := &Principal{ID: "1", Roles: []string{"admin"}}
+
+		token, err := SignToken(testSecret, principal, time.Now().Add(time.Minute))
+		So(err, ShouldBeNil)
+
+		Convey("should verify signed tokens", func() {
+			verified, err := verifier.Verify(context.Background(), &Credentials{Scheme: "Bearer", Token: token})
+
+			So(err, ShouldBeNil)
+			So(verified.ID, ShouldEqual, "1")
+			So(verified.HasRole("admin"), ShouldBeTrue)
+		})
+
+		Convey("should reject expired tokens", func() {
+			verifier.Now = func() time.Time { return time.Now().Add(time.Hour) }
+			_, err := verifier.Verify(context.Background(), &Credentials{Scheme: "Bearer", Token: token})
+
+			So(err, ShouldEqual, ErrTokenExpired)
+		})
+	})
+}
+
+func TestCredentialExtractors(t *testing.T) {
+
+	Convey("Credential Extractor Tests", t, func() {
+
+		request := httptest.NewRequest("GET", "/bars?api_key=query", nil)
+
+		Convey("->BasicAuth()", func() {
+			request.SetBasicAuth("user", "pass")
+			credentials := BasicAuth(request)
+
+			So(credentials.Username, ShouldEqual, "user")
+			So(credentials.Password, ShouldEqual, "pass")
+			So(BearerToken(request), ShouldBeNil)
+		})
+
+		Convey("->APIKeyHeader()", func() {
+			So(APIKeyHeader("X-API-Key")(request), ShouldBeNil)
+
+			request.Header.Set("X-API-Key", "header")
+			So(APIKeyHeader("X-API-Key")(request).Token, ShouldEqual, "header")
+		})
+
+		Convey("->APIKeyQuery()", func() {
+			So(APIKeyQuery("api_key")(request).Token, ShouldEqual, "query")
+		})
+	})
+}
@@ -0,0 +1,355 @@
+package jshapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/jsh-api/store"
+)
+
+/*
+Bulk is an opt-in shortcut for registering all collection wide mutation routes
+for a compatible bulk storage implementation:
+
+Registers handlers for:
+	POST   /resource
+	PATCH  /resource
+	DELETE /resource
+
+Each route accepts a list of objects (or resource identifiers for DELETE) as the
+top level "data" member. Errors reported for an individual object point at its
+index within the request, i.e. "/data/3/attributes/name".
+*/
+func (res *Resource) Bulk(storage store.BulkCRUD) {
+	res.BulkPost(storage.SaveAll)
+	res.BulkPatch(storage.UpdateAll)
+	res.BulkDelete(storage.DeleteAll)
+}
+
+// BulkPost enables `POST /resource` requests containing a list of objects. If a
+// single object storage handler has already been registered via .Post(), it
+// continues to handle requests containing a single object. Otherwise single
+// objects are saved via the bulk storage as a list of one.
+func (res *Resource) BulkPost(storage store.BulkSave) {
+	res.bulkSave = storage
+
+	// .Post() dispatches lists to bulkSave itself, so only add a route if one
+	// doesn't exist yet
+	if res.hasRoute(post, patRoot) {
+		return
+	}
+
+	res.handle(
+		res.pattern(post, patRoot),
+		OpPost,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.postHandler(w, r, nil)
+		},
+	)
+
+	res.addRoute(post, patRoot)
+}
+
+// BulkPatch registers a `PATCH /resource` handler that updates a list of objects
+func (res *Resource) BulkPatch(storage store.BulkUpdate) {
+	res.handle(
+		res.pattern(patch, patRoot),
+		OpPatch,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.bulkPatchHandler(w, r, storage)
+		},
+	)
+
+	res.addRoute(patch, patRoot)
+}
+
+// BulkDelete registers a `DELETE /resource` handler that deletes each resource
+// identified within the request body
+func (res *Resource) BulkDelete(storage store.BulkDelete) {
+	res.handle(
+		res.pattern(del, patRoot),
+		OpDelete,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.bulkDeleteHandler(w, r, storage)
+		},
+	)
+
+	res.addRoute(del, patRoot)
+}
+
+/*
+BulkError scopes an error to the object found at index within a bulk request. An
+error with a Source.Pointer of "/data/attributes/name" becomes
+"/data/3/attributes/name" for index 3. Storage implementations can use this to
+report which objects in a list failed.
+*/
+func BulkError(index int, err *jsh.Error) *jsh.Error {
+	scoped := *err
+	prefix := fmt.Sprintf("/data/%d", index)
+
+	pointer := err.Source.Pointer
+	switch {
+	case pointer == "" || pointer == "/data":
+		scoped.Source.Pointer = prefix
+	case strings.HasPrefix(pointer, "/data/"):
+		scoped.Source.Pointer = prefix + strings.TrimPrefix(pointer, "/data")
+	}
+
+	return &scoped
+}
+
+// POST /resources with a list payload
+func (res *Resource) bulkPostHandler(w http.ResponseWriter, r *http.Request, storage store.BulkSave) {
+	ctx := r.Context()
+
+	isList := isBulkRequest(r)
+
+	// jsh.ParseList requires IDs for every object in a multi-object list, which
+	// new objects won't have yet
+	list, parseErr := parseBulkList(r)
+	if parseErr != nil {
+		SendHandler(ctx, w, r, parseErr)
+		return
+	}
+
+	validationErr := res.validateBulkList(list, false)
+	if validationErr != nil {
+		SendHandler(ctx, w, r, validationErr)
+		return
+	}
+
+	if !res.authorizeList(w, r, OpPost, list) {
+		return
+	}
+
+	mutation := res.observeMutation(ctx, OpPost)
+
+	storageCtx, observe := res.instrument(ctx, "")
+	saved, err := storage(storageCtx, list)
+	observe(err)
+	sendableErr := res.storageError(err, "")
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
+		return
+	}
+
+	recordErr := mutation.record(ctx, saved...)
+	if recordErr != nil {
+		SendHandler(ctx, w, r, recordErr)
+		return
+	}
+
+	if !isList && len(saved) == 1 {
+		res.send(w, r, saved[0])
+		return
+	}
+
+	res.send(w, r, saved)
+}
+
+// PATCH /resources
+func (res *Resource) bulkPatchHandler(w http.ResponseWriter, r *http.Request, storage store.BulkUpdate) {
+	ctx := r.Context()
+
+	list, parseErr := jsh.ParseList(r)
+	if parseErr != nil {
+		SendHandler(ctx, w, r, parseErr)
+		return
+	}
+
+	validationErr := res.validateBulkList(list, true)
+	if validationErr != nil {
+		SendHandler(ctx, w, r, validationErr)
+		return
+	}
+
+	if !res.authorizeList(w, r, OpPatch, list) {
+		return
+	}
+
+	ids := []string{}
+	for _, object := range list {
+		ids = append(ids, object.ID)
+	}
+
+	mutation := res.observeMutation(ctx, OpPatch, ids...)
+
+	storageCtx, observe := res.instrument(ctx, "")
+	updated, err := storage(storageCtx, list)
+	observe(err)
+	sendableErr := res.storageError(err, "")
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
+		return
+	}
+
+	recordErr := mutation.record(ctx, updated...)
+	if recordErr != nil {
+		SendHandler(ctx, w, r, recordErr)
+		return
+	}
+
+	res.send(w, r, updated)
+}
+
+// DELETE /resources
+func (res *Resource) bulkDeleteHandler(w http.ResponseWriter, r *http.Request, storage store.BulkDelete) {
+	ctx := r.Context()
+
+	identifiers, parseErr := jsh.ParseList(r)
+	if parseErr != nil {
+		SendHandler(ctx, w, r, parseErr)
+		return
+	}
+
+	validationErr := res.validateBulkList(identifiers, true)
+	if validationErr != nil {
+		SendHandler(ctx, w, r, validationErr)
+		return
+	}
+
+	if !res.authorizeList(w, r, OpDelete, identifiers) {
+		return
+	}
+
+	ids := []string{}
+	for _, identifier := range identifiers {
+		ids = append(ids, identifier.ID)
+	}
+
+	if res.softDeletes != nil {
+		storage = res.softDeletes.deleteAll
+	}
+
+	mutation := res.observeMutation(ctx, OpDelete, ids...)
+
+	storageCtx, observe := res.instrument(ctx, "")
+	err := storage(storageCtx, ids)
+	observe(err)
+	sendableErr := res.storageError(err, "")
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
+		return
+	}
+
+	recordErr := mutation.record(ctx)
+	if recordErr != nil {
+		SendHandler(ctx, w, r, recordErr)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateBulkList ensures each object in the list belongs to this resource, and
+// has an ID if required. Returns an error for every invalid object.
+func (res *Resource) validateBulkList(list jsh.List, requireID bool) jsh.ErrorType {
+	errors := jsh.ErrorList{}
+
+	for index, object := range list {
+		if object.Type != res.Type {
+			typeErr := &jsh.Error{
+				Title:  "Conflicting Type",
+				Detail: fmt.Sprintf("Expected type '%s', got '%s'", res.Type, object.Type),
+				Status: http.StatusConflict,
+			}
+			typeErr.Source.Pointer = "/data/type"
+			errors = append(errors, BulkError(index, typeErr))
+		}
+
+		if requireID && object.ID == "" {
+			idErr := jsh.InputError("Missing mandatory object attribute", "id")
+			idErr.Source.Pointer = "/data/id"
+			errors = append(errors, BulkError(index, idErr))
+		}
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+
+	return errors
+}
+
+// isBulkRequest peeks at the request body to check whether the top level "data"
+// member is a list. The body is restored so it can still be parsed afterwards.
+func isBulkRequest(r *http.Request) bool {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	payload := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		return false
+	}
+
+	data := bytes.TrimSpace(payload.Data)
+	return len(data) > 0 && data[0] == '['
+}
+
+// parseBulkList mirrors jsh.ParseList, but allows objects without IDs so that a
+// list of new objects can be created
+func parseBulkList(r *http.Request) (jsh.List, *jsh.Error) {
+	defer r.Body.Close()
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType != jsh.ContentType {
+		return nil, jsh.SpecificationError(fmt.Sprintf(
+			"Expected Content-Type header to be %s, got: %s",
+			jsh.ContentType,
+			contentType,
+		))
+	}
+
+	document := &jsh.Document{
+		Data: jsh.List{},
+		Mode: jsh.ListMode,
+	}
+
+	decodeErr := json.NewDecoder(r.Body).Decode(document)
+	if decodeErr != nil {
+		return nil, jsh.SpecificationError(fmt.Sprintf("Error parsing JSON Document: %s", decodeErr.Error()))
+	}
+
+	for index, object := range document.Data {
+		inputErr := validateBulkInput(object)
+		if inputErr != nil {
+			return nil, BulkError(index, inputErr)
+		}
+	}
+
+	return document.Data, nil
+}
+
+// validateBulkInput applies the input validation jsh.ParseObject does to each
+// object of a list
+func validateBulkInput(object *jsh.Object) *jsh.Error {
+	if object == nil {
+		return jsh.SpecificationError("Data must only contain resource objects")
+	}
+
+	_, validationErr := govalidator.ValidateStruct(object)
+	if errorList, isType := validationErr.(govalidator.Errors); isType && len(errorList) > 0 {
+		fieldErr, _ := errorList.Errors()[0].(govalidator.Error)
+
+		// the validated fields are members of the object rather than attributes
+		inputErr := jsh.InputError(fieldErr.Err.Error(), fieldErr.Name)
+		inputErr.Source.Pointer = "/data/" + strings.ToLower(fieldErr.Name)
+		return inputErr
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package jshapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBulk(t *testing.T) {
+
+	resource := NewMockResource(testResourceType, 2, testObjAttrs)
+	resource.Bulk(&MockStorage{ResourceType: testResourceType})
+
+	api := New("")
+	api.Add(resource)
+
+	server := httptest.NewServer(api)
+	baseURL := server.URL + "/" + testResourceType
+
+	Convey("Bulk Tests", t, func() {
+
+		Convey("Resource State", func() {
+			So(len(resource.Routes), ShouldEqual, 7)
+		})
+
+		Convey("->BulkPost()", func() {
+
+			Convey("should save a list of objects", func() {
+				list := jsh.List{
+					sampleObject("", testResourceType, testObjAttrs),
+					sampleObject("", testResourceType, testObjAttrs),
+				}
+
+				doc, resp, err := jsc.Do(bulkRequest("POST", baseURL, list), jsh.ListMode)
+
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(len(doc.Data), ShouldEqual, 2)
+				So(doc.Data[1].ID, ShouldEqual, "2")
+			})
+
+			Convey("should still accept a single object", func() {
+				object := sampleObject("", testResourceType, testObjAttrs)
+				doc, resp, err := jsc.Post(server.URL, object)
+
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+				So(doc.Data[0].ID, ShouldEqual, "1")
+			})
+
+			Convey("should point errors at the offending object", func() {
+				list := jsh.List{
+					sampleObject("", testResourceType, testObjAttrs),
+					sampleObject("", "baz", testObjAttrs),
+				}
+
+				doc, resp, err := jsc.Do(bulkRequest("POST", baseURL, list), jsh.ListMode)
+
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusConflict)
+				So(len(doc.Errors), ShouldEqual, 1)
+				So(doc.Errors[0].Source.Pointer, ShouldEqual, "/data/1/type")
+			})
+
+			Convey("should validate each object", func() {
+				list := jsh.List{
+					sampleObject("", testResourceType, testObjAttrs),
+					&jsh.Object{Attributes: json.RawMessage(`{"foo":"bar"}`)},
+				}
+
+				doc, resp, err := jsc.Do(bulkRequest("POST", baseURL, list), jsh.ListMode)
+
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, 422)
+				So(doc.Errors[0].Source.Pointer, ShouldEqual, "/data/1/type")
+			})
+
+			Convey("should reject malformed documents", func() {
+				request, err := http.NewRequest("POST", baseURL, strings.NewReader(`{"data": [{"type": 1}]}`))
+				So(err, ShouldBeNil)
+				request.Header.Set("Content-Type", jsh.ContentType)
+
+				resp, err := http.DefaultClient.Do(request)
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusNotAcceptable)
+			})
+		})
+
+		Convey("->BulkPatch()", func() {
+
+			Convey("should update a list of objects", func() {
+				list := jsh.List{
+					sampleObject("1", testResourceType, testObjAttrs),
+					sampleObject("2", testResourceType, testObjAttrs),
+				}
+
+				doc, resp, err := jsc.Do(bulkRequest("PATCH", baseURL, list), jsh.ListMode)
+
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(len(doc.Data), ShouldEqual, 2)
+			})
+
+			Convey("should require IDs", func() {
+				list := jsh.List{sampleObject("", testResourceType, testObjAttrs)}
+
+				doc, resp, err := jsc.Do(bulkRequest("PATCH", baseURL, list), jsh.ListMode)
+
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, 422)
+				So(doc.Errors[0].Source.Pointer, ShouldEqual, "/data/0/id")
+			})
+		})
+
+		Convey("->BulkDelete()", func() {
+			list := jsh.List{
+				&jsh.Object{Type: testResourceType, ID: "1"},
+				&jsh.Object{Type: testResourceType, ID: "2"},
+			}
+
+			_, resp, err := jsc.Do(bulkRequest("DELETE", baseURL, list), jsh.ListMode)
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+		})
+	})
+}
+
+func TestBulkError(t *testing.T) {
+
+	Convey("BulkError Tests", t, func() {
+
+		Convey("should scope attribute pointers", func() {
+			err := BulkError(3, jsh.InputError("Invalid name", "name"))
+			So(err.Source.Pointer, ShouldEqual, "/data/3/attributes/name")
+		})
+
+		Convey("should point at the object when no pointer is set", func() {
+			err := BulkError(1, jsh.ISE("failed"))
+			So(err.Source.Pointer, ShouldEqual, "/data/1")
+		})
+	})
+}
+
+// bulkRequest builds a JSON API request containing a list of objects
+func bulkRequest(method string, url string, list jsh.List) *http.Request {
+	content, err := json.Marshal(jsh.Build(list))
+	if err != nil {
+		panic(err)
+	}
+
+	request, err := jsc.NewRequest(method, url, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	request.Body = jsh.CreateReadCloser(content)
+	request.ContentLength = int64(len(content))
+
+	return request
+}
@@ -0,0 +1,142 @@
+package jshapi
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsMethods are the methods a resource can register handlers for
+var corsMethods = []string{get, post, patch, del}
+
+/*
+CORS configures Cross-Origin Resource Sharing for an API, see API.EnableCORS.
+*/
+type CORS struct {
+	// AllowedOrigins may contain wildcards, i.e. "https://*.example.com", or "*"
+	// to allow any origin
+	AllowedOrigins []string
+	// AllowedHeaders are the request headers a browser may send, defaults to
+	// Accept, Authorization, and Content-Type
+	AllowedHeaders []string
+	// ExposedHeaders are response headers the browser makes available to scripts
+	ExposedHeaders []string
+	// AllowCredentials allows cookies and Authorization headers to be sent
+	AllowCredentials bool
+	// MaxAge is how long a browser may cache a preflight response
+	MaxAge time.Duration
+}
+
+/*
+EnableCORS adds a CORS middleware to the API. Preflight requests are answered with
+the methods actually registered for the requested route, so they can't drift from
+what the resource supports:
+
+	api.EnableCORS(jshapi.CORS{
+		AllowedOrigins: []string{"https://*.example.com"},
+		ExposedHeaders: []string{"Location", "RateLimit-Remaining"},
+		MaxAge:         time.Hour,
+	})
+
+As preflight requests carry no credentials, enable CORS before adding an
+Authenticator.
+*/
+func (a *API) EnableCORS(cors CORS) {
+	if len(cors.AllowedHeaders) == 0 {
+		cors.AllowedHeaders = []string{"Accept", "Authorization", "Content-Type"}
+	}
+
+	a.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			header.Add("Vary", "Origin")
+
+			allowed := cors.allowsOrigin(origin)
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if !preflight {
+				if allowed {
+					cors.allowOrigin(header, origin)
+					if len(cors.ExposedHeaders) > 0 {
+						header.Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+					}
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			methods := a.allowedMethods(r)
+			if len(methods) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if allowed {
+				cors.allowOrigin(header, origin)
+				header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+				header.Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+				if cors.MaxAge > 0 {
+					header.Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Seconds())))
+				}
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		})
+	})
+}
+
+// allowsOrigin checks an origin against the allowed origins
+func (c *CORS) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+
+		matched, err := path.Match(allowed, origin)
+		if err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowOrigin sets the headers allowing an origin to read the response
+func (c *CORS) allowOrigin(header http.Header, origin string) {
+	// a literal "*" can't be used along with credentials
+	allowedOrigin := origin
+	if !c.AllowCredentials && len(c.AllowedOrigins) == 1 && c.AllowedOrigins[0] == "*" {
+		allowedOrigin = "*"
+	}
+
+	header.Set("Access-Control-Allow-Origin", allowedOrigin)
+	if c.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// allowedMethods finds every method registered for the route a request is for
+func (a *API) allowedMethods(r *http.Request) []string {
+	methods := []string{}
+
+	for _, method := range corsMethods {
+		probe := new(http.Request)
+		*probe = *r
+		probe.Method = method
+
+		if _, routed := a.route(probe); routed {
+			methods = append(methods, method)
+		}
+	}
+
+	return methods
+}
@@ -0,0 +1,76 @@
+package jshapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCORS(t *testing.T) {
+
+	resource := NewResource(testResourceType)
+	mock := &MockStorage{ResourceType: testResourceType, ResourceAttributes: testObjAttrs, ListCount: 1}
+	resource.Get(mock.Get)
+	resource.Patch(mock.Update)
+	resource.List(mock.List)
+
+	api := New("api")
+	api.EnableCORS(CORS{
+		AllowedOrigins: []string{"https://*.example.com"},
+		ExposedHeaders: []string{"Location"},
+		MaxAge:         time.Hour,
+	})
+	api.Add(resource)
+
+	server := httptest.NewServer(api)
+	baseURL := server.URL + api.prefix
+
+	preflight := func(url string, origin string) *http.Response {
+		request, err := http.NewRequest(http.MethodOptions, url, nil)
+		So(err, ShouldBeNil)
+		request.Header.Set("Origin", origin)
+		request.Header.Set("Access-Control-Request-Method", "PATCH")
+
+		resp, err := http.DefaultClient.Do(request)
+		So(err, ShouldBeNil)
+		return resp
+	}
+
+	Convey("CORS Tests", t, func() {
+
+		Convey("should answer preflights with the registered methods", func() {
+			resp := preflight(baseURL+"/bars/1", "https://app.example.com")
+
+			So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+			So(resp.Header.Get("Access-Control-Allow-Origin"), ShouldEqual, "https://app.example.com")
+			So(resp.Header.Get("Access-Control-Allow-Methods"), ShouldEqual, "GET, PATCH")
+			So(resp.Header.Get("Access-Control-Max-Age"), ShouldEqual, "3600")
+
+			resp = preflight(baseURL+"/bars", "https://app.example.com")
+			So(resp.Header.Get("Access-Control-Allow-Methods"), ShouldEqual, "GET")
+		})
+
+		Convey("should not allow unknown origins", func() {
+			resp := preflight(baseURL+"/bars/1", "https://evil.com")
+
+			So(resp.Header.Get("Access-Control-Allow-Origin"), ShouldEqual, "")
+		})
+
+		Convey("should decorate actual requests", func() {
+			request, err := jsc.FetchRequest(baseURL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			request.Header.Set("Origin", "https://app.example.com")
+
+			_, resp, err := jsc.Do(request, jsh.ObjectMode)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Access-Control-Allow-Origin"), ShouldEqual, "https://app.example.com")
+			So(resp.Header.Get("Access-Control-Expose-Headers"), ShouldEqual, "Location")
+		})
+	})
+}
@@ -0,0 +1,184 @@
+package jshapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"reflect"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/jsh-api/store"
+)
+
+/*
+ErrorMapping builds the JSON API error that is sent in place of an error returned
+by storage. The type and ID of the resource being handled are provided when
+known.
+*/
+type ErrorMapping func(err error, resourceType string, id string) jsh.ErrorType
+
+/*
+ErrorMapper translates plain Go errors returned by storage into JSON API errors.
+Errors that already implement jsh.ErrorType are sent as is, errors that match a
+registered mapping are translated, and anything else becomes an ISE carrying the
+original error message internally.
+
+By default sql.ErrNoRows is sent as a 404, context.DeadlineExceeded as a 504, and
+store.ErrCircuitOpen as a 503.
+Register your own sentinel or typed errors on an API like so:
+
+	api := jshapi.New("")
+	api.Errors.Register(ErrUserBanned, &jsh.Error{
+		Title:  "Banned",
+		Detail: "This user has been banned",
+		Status: http.StatusForbidden,
+	})
+*/
+type ErrorMapper struct {
+	mappings []errorMapping
+}
+
+// errorMapping pairs a matcher with the mapping used for errors it matches
+type errorMapping struct {
+	matches func(error) bool
+	mapping ErrorMapping
+}
+
+// DefaultErrors is used by resources that have not been added to an API
+var DefaultErrors = NewErrorMapper()
+
+// NewErrorMapper creates an ErrorMapper with the default mappings registered
+func NewErrorMapper() *ErrorMapper {
+	mapper := &ErrorMapper{}
+
+	mapper.RegisterFunc(
+		func(err error) bool { return errors.Is(err, sql.ErrNoRows) },
+		func(err error, resourceType string, id string) jsh.ErrorType {
+			return jsh.NotFound(resourceType, id)
+		},
+	)
+
+	mapper.Register(context.DeadlineExceeded, &jsh.Error{
+		Title:  "Gateway Timeout",
+		Detail: "The request took too long to complete",
+		Status: http.StatusGatewayTimeout,
+	})
+
+	mapper.Register(store.ErrCircuitOpen, &jsh.Error{
+		Title:  "Service Unavailable",
+		Detail: "Storage is temporarily unavailable",
+		Status: http.StatusServiceUnavailable,
+	})
+
+	return mapper
+}
+
+/*
+Register sends a copy of mapped whenever storage returns an error matching target,
+as determined by errors.Is. Later registrations take precedence over earlier ones.
+*/
+func (m *ErrorMapper) Register(target error, mapped *jsh.Error) {
+	m.RegisterFunc(
+		func(err error) bool { return errors.Is(err, target) },
+		copyMapping(mapped),
+	)
+}
+
+/*
+RegisterType sends a copy of mapped whenever storage returns an error of the same
+type as example, as determined by errors.As:
+
+	api.Errors.RegisterType(&ValidationError{}, &jsh.Error{
+		Title:  "Invalid",
+		Status: http.StatusBadRequest,
+	})
+*/
+func (m *ErrorMapper) RegisterType(example error, mapped *jsh.Error) {
+	exampleType := reflect.TypeOf(example)
+
+	m.RegisterFunc(
+		func(err error) bool {
+			return errors.As(err, reflect.New(exampleType).Interface())
+		},
+		copyMapping(mapped),
+	)
+}
+
+// RegisterFunc adds a custom mapping for all errors accepted by matches
+func (m *ErrorMapper) RegisterFunc(matches func(error) bool, mapping ErrorMapping) {
+	m.mappings = append(m.mappings, errorMapping{
+		matches: matches,
+		mapping: mapping,
+	})
+}
+
+// Map translates an error into a sendable JSON API error
+func (m *ErrorMapper) Map(err error, resourceType string, id string) jsh.ErrorType {
+	if errorType, isType := err.(jsh.ErrorType); isType {
+		return errorType
+	}
+
+	for i := len(m.mappings) - 1; i >= 0; i-- {
+		if m.mappings[i].matches(err) {
+			return m.mappings[i].mapping(err, resourceType, id)
+		}
+	}
+
+	var wrapped *jsh.Error
+	if errors.As(err, &wrapped) {
+		return wrapped
+	}
+
+	return jsh.ISE(err.Error())
+}
+
+// copyMapping builds a mapping that sends a copy of the provided error, keeping
+// the original error message internally
+func copyMapping(mapped *jsh.Error) ErrorMapping {
+	return func(err error, resourceType string, id string) jsh.ErrorType {
+		copied := *mapped
+		copied.ISE = err.Error()
+		return &copied
+	}
+}
+
+/*
+normalizeError is the single place where typed nils returned by storage are
+handled. Storage that declares a `var err *jsh.Error` or `jsh.ErrorList` and
+returns it as an error produces a non-nil error interface wrapping a nil value,
+which is collapsed back into an untyped nil here using a type switch rather than
+reflection. Storage returning other error types must return a literal nil.
+*/
+func normalizeError(err error) error {
+	switch typedErr := err.(type) {
+	case nil:
+		return nil
+	case *jsh.Error:
+		if typedErr == nil {
+			return nil
+		}
+	case jsh.ErrorList:
+		if len(typedErr) == 0 {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// storageError converts an error returned by storage into a sendable error,
+// returning nil if storage succeeded
+func (res *Resource) storageError(err error, id string) jsh.ErrorType {
+	err = normalizeError(err)
+	if err == nil {
+		return nil
+	}
+
+	mapper := res.errors
+	if mapper == nil {
+		mapper = DefaultErrors
+	}
+
+	return mapper.Map(err, res.Type, id)
+}
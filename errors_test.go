@@ -0,0 +1,189 @@
+package jshapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	"github.com/derekdowling/jsh-api/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var errBanned = errors.New("user is banned")
+
+type quotaError struct {
+	Limit int
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("quota of %d exceeded", e.Limit)
+}
+
+func TestErrorMapper(t *testing.T) {
+
+	Convey("ErrorMapper Tests", t, func() {
+
+		mapper := NewErrorMapper()
+
+		Convey("should send jsh errors as is", func() {
+			notFound := jsh.NotFound(testResourceType, "1")
+			So(mapper.Map(notFound, testResourceType, "1"), ShouldEqual, notFound)
+		})
+
+		Convey("should map sql.ErrNoRows to a 404", func() {
+			err := mapper.Map(fmt.Errorf("fetching user: %w", sql.ErrNoRows), testResourceType, "1")
+			So(err.StatusCode(), ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("should map context.DeadlineExceeded to a 504", func() {
+			err := mapper.Map(context.DeadlineExceeded, testResourceType, "1")
+			So(err.StatusCode(), ShouldEqual, http.StatusGatewayTimeout)
+		})
+
+		Convey("should map store.ErrCircuitOpen to a 503", func() {
+			err := mapper.Map(store.ErrCircuitOpen, testResourceType, "1")
+			So(err.StatusCode(), ShouldEqual, http.StatusServiceUnavailable)
+
+			breaker := store.NewCircuitBreaker(store.CircuitBreakerOptions{Failures: 1})
+			resource := NewCRUDResource(testResourceType, store.NewMemory(testResourceType), breaker.Middleware)
+			server := httptest.NewServer(resource)
+			defer server.Close()
+
+			// a 404 is the caller's fault and leaves the circuit closed
+			_, resp, _ := jsc.Fetch(server.URL, testResourceType, "1")
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+			So(breaker.State(), ShouldEqual, store.CircuitClosed)
+		})
+
+		Convey("->Register()", func() {
+			mapper.Register(errBanned, &jsh.Error{
+				Title:  "Banned",
+				Detail: "This user has been banned",
+				Status: http.StatusForbidden,
+			})
+
+			err := mapper.Map(errBanned, testResourceType, "1")
+			So(err.StatusCode(), ShouldEqual, http.StatusForbidden)
+			So(err.(*jsh.Error).ISE, ShouldEqual, errBanned.Error())
+		})
+
+		Convey("->RegisterType()", func() {
+			mapper.RegisterType(&quotaError{}, &jsh.Error{
+				Title:  "Quota Exceeded",
+				Detail: "Upgrade your plan",
+				Status: http.StatusPaymentRequired,
+			})
+
+			err := mapper.Map(fmt.Errorf("saving: %w", &quotaError{Limit: 5}), testResourceType, "")
+			So(err.StatusCode(), ShouldEqual, http.StatusPaymentRequired)
+		})
+
+		Convey("should send unmapped errors as an ISE", func() {
+			err := mapper.Map(errors.New("connection refused"), testResourceType, "1")
+			So(err.StatusCode(), ShouldEqual, http.StatusInternalServerError)
+			So(err.(*jsh.Error).ISE, ShouldEqual, "connection refused")
+		})
+	})
+}
+
+func TestStorageErrors(t *testing.T) {
+
+	resource := NewResource(testResourceType)
+	resource.Get(func(ctx context.Context, id string) (*jsh.Object, error) {
+		if id == "banned" {
+			return nil, errBanned
+		}
+
+		return nil, sql.ErrNoRows
+	})
+
+	api := New("")
+	api.Errors.Register(errBanned, &jsh.Error{
+		Title:  "Banned",
+		Detail: "This user has been banned",
+		Status: http.StatusForbidden,
+	})
+	api.Add(resource)
+
+	server := httptest.NewServer(api)
+	baseURL := server.URL
+
+	Convey("Storage Error Tests", t, func() {
+
+		Convey("should use the default mappings", func() {
+			_, resp, err := jsc.Fetch(baseURL, testResourceType, "1")
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("should use mappings registered on the API", func() {
+			doc, resp, err := jsc.Fetch(baseURL, testResourceType, "banned")
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			So(doc.Errors[0].Title, ShouldEqual, "Banned")
+		})
+	})
+}
+
+func TestNormalizeError(t *testing.T) {
+
+	Convey("normalizeError Tests", t, func() {
+
+		Convey("should collapse a typed nil *jsh.Error", func() {
+			var typedNil *jsh.Error
+			var err error = typedNil
+
+			So(err != nil, ShouldBeTrue)
+			So(normalizeError(err), ShouldBeNil)
+		})
+
+		Convey("should collapse an empty jsh.ErrorList", func() {
+			var list jsh.ErrorList
+			So(normalizeError(list), ShouldBeNil)
+		})
+
+		Convey("should keep real errors", func() {
+			So(normalizeError(errBanned), ShouldEqual, errBanned)
+
+			list := jsh.ErrorList{jsh.ISE("failed")}
+			So(normalizeError(list), ShouldNotBeNil)
+		})
+	})
+}
+
+// reflectIsNil is the check every handler performed before normalizeError, kept
+// as a baseline for comparison
+func reflectIsNil(err error) bool {
+	return err == nil || reflect.ValueOf(err).IsNil()
+}
+
+func BenchmarkReflectNilCheck(b *testing.B) {
+	var typedNil *jsh.Error
+	var err error = typedNil
+
+	for i := 0; i < b.N; i++ {
+		if !reflectIsNil(err) {
+			b.Fatal("expected nil")
+		}
+	}
+}
+
+func BenchmarkNormalizeError(b *testing.B) {
+	var typedNil *jsh.Error
+	var err error = typedNil
+
+	for i := 0; i < b.N; i++ {
+		if normalizeError(err) != nil {
+			b.Fatal("expected nil")
+		}
+	}
+}
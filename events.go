@@ -0,0 +1,342 @@
+package jshapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+// Change event types
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+)
+
+const (
+	// DefaultEventBuffer is how many events a ChangeFeed keeps for resuming
+	DefaultEventBuffer = 1024
+	// DefaultSubscriberBuffer is how many events can be queued for a subscriber
+	// before it is considered too slow and disconnected
+	DefaultSubscriberBuffer = 64
+	// DefaultEventHeartbeat is how often idle streams are sent a comment, to keep
+	// connections from being closed by proxies
+	DefaultEventHeartbeat = 15 * time.Second
+)
+
+/*
+ChangeEvent describes an object that was created, updated, or deleted. Object is the
+object after the change, or before it was deleted if it was snapshotted.
+*/
+type ChangeEvent struct {
+	ID           uint64
+	Type         string
+	ResourceType string
+	ResourceID   string
+	Object       *jsh.Object
+
+	// resource filters the event for each subscriber
+	resource *Resource
+}
+
+/*
+ChangeListener is notified of every change made through the handlers of the
+resources it listens to, see Resource.Listen and API.Listen. Errors are sent to the
+client as an ISE, although the change has already been made.
+*/
+type ChangeListener interface {
+	Publish(ctx context.Context, event *ChangeEvent) error
+}
+
+/*
+ChangeFeed streams change events to subscribers using Server-Sent Events. Feeds are
+fed automatically by the POST, PATCH, and DELETE handlers of the resources they are
+registered for, see Resource.Events and API.Events.
+
+Each event is sent with its ID, and recent events are buffered so that clients
+reconnecting with a Last-Event-ID header receive the events they missed. Events are
+filtered for each subscriber by the resource's authorization policies, tenancy,
+and attribute permissions. Subscribers that fall too far behind are disconnected,
+rather than slowing down the feed, and can resume where they left off.
+*/
+type ChangeFeed struct {
+	// Buffer is how many events are kept for resuming
+	Buffer int
+	// SubscriberBuffer is how many events can be queued for each subscriber
+	SubscriberBuffer int
+	// Heartbeat is how often idle streams are sent a comment
+	Heartbeat time.Duration
+
+	mutex       sync.Mutex
+	lastID      uint64
+	events      []*ChangeEvent
+	start       int
+	subscribers map[*subscriber]bool
+}
+
+// subscriber is a single stream
+type subscriber struct {
+	events chan *ChangeEvent
+	// dropped is closed when the subscriber falls too far behind
+	dropped chan struct{}
+}
+
+// NewChangeFeed creates a ChangeFeed with the default buffer sizes
+func NewChangeFeed() *ChangeFeed {
+	return &ChangeFeed{
+		Buffer:           DefaultEventBuffer,
+		SubscriberBuffer: DefaultSubscriberBuffer,
+		Heartbeat:        DefaultEventHeartbeat,
+		subscribers:      map[*subscriber]bool{},
+	}
+}
+
+// Publish implements ChangeListener, assigning an ID to event, buffering it, and
+// queueing it for every subscriber
+func (f *ChangeFeed) Publish(ctx context.Context, event *ChangeEvent) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.lastID++
+	event.ID = f.lastID
+
+	// events is a ring buffer, with the oldest event at start once it is full
+	if len(f.events) < f.Buffer {
+		f.events = append(f.events, event)
+	} else if f.Buffer > 0 {
+		f.events[f.start] = event
+		f.start = (f.start + 1) % f.Buffer
+	}
+
+	for sub := range f.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			delete(f.subscribers, sub)
+			close(sub.dropped)
+		}
+	}
+
+	return nil
+}
+
+// subscribe adds a subscriber, returning the buffered events after lastID that it
+// missed
+func (f *ChangeFeed) subscribe(lastID uint64, resume bool) (*subscriber, []*ChangeEvent) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	missed := []*ChangeEvent{}
+	if resume {
+		for i := range f.events {
+			event := f.events[(f.start+i)%len(f.events)]
+			if event.ID > lastID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	sub := &subscriber{
+		events:  make(chan *ChangeEvent, f.SubscriberBuffer),
+		dropped: make(chan struct{}),
+	}
+	f.subscribers[sub] = true
+
+	return sub, missed
+}
+
+// unsubscribe removes a subscriber once its stream closes
+func (f *ChangeFeed) unsubscribe(sub *subscriber) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	delete(f.subscribers, sub)
+}
+
+// ServeHTTP streams events to a client until it disconnects
+func (f *ChangeFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	controller := http.NewResponseController(w)
+
+	lastID, parseErr := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	sub, missed := f.subscribe(lastID, parseErr == nil)
+	defer f.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range missed {
+		f.write(w, r, event)
+	}
+
+	if controller.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(f.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.dropped:
+			return
+		case event := <-sub.events:
+			f.write(w, r, event)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+
+		if controller.Flush() != nil {
+			return
+		}
+	}
+}
+
+// write sends a single event, if the subscriber may see it
+func (f *ChangeFeed) write(w http.ResponseWriter, r *http.Request, event *ChangeEvent) {
+	payload, visible := event.resource.visible(r.Context(), event)
+	if !visible {
+		return
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"data": payload})
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
+
+// eventIdentifier is the payload of deleted events
+type eventIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+/*
+Events registers a `GET /resource/events` handler streaming the resource's changes
+over Server-Sent Events, and returns its feed. Subscribing is authorized as OpList.
+*/
+func (res *Resource) Events() *ChangeFeed {
+	feed := NewChangeFeed()
+	res.Listen(feed)
+
+	res.handle(
+		res.pattern(get, "/events"),
+		OpList,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.eventsHandler(w, r, feed)
+		},
+	)
+
+	res.addRoute(get, "/events")
+	return feed
+}
+
+// GET /resources/events
+func (res *Resource) eventsHandler(w http.ResponseWriter, r *http.Request, feed *ChangeFeed) {
+	if !res.authorize(w, r, OpList, Target{}) {
+		return
+	}
+
+	feed.ServeHTTP(w, r)
+}
+
+/*
+Events registers a `GET /(prefix/)events` handler streaming the changes of every
+resource in the API over Server-Sent Events, and returns its feed.
+*/
+func (a *API) Events() *ChangeFeed {
+	if a.events != nil {
+		return a.events
+	}
+
+	a.events = NewChangeFeed()
+	a.Listen(a.events)
+
+	a.ServeMux.Handle(fmt.Sprintf("%s %s", get, path.Join(a.prefix, "events")), a.events)
+	return a.events
+}
+
+// Listen notifies listener of every change made through the resource's handlers
+func (res *Resource) Listen(listener ChangeListener) {
+	res.listeners = append(res.listeners, listener)
+}
+
+// Listen notifies listener of every change made through the handlers of the API's
+// resources, including those added later
+func (a *API) Listen(listener ChangeListener) {
+	a.listeners = append(a.listeners, listener)
+
+	for _, resource := range a.Resources {
+		resource.Listen(listener)
+	}
+}
+
+// publish notifies the resource's listeners of a change
+func (res *Resource) publish(ctx context.Context, entry *AuditEntry) jsh.ErrorType {
+	eventType := ""
+	switch entry.Operation {
+	case OpPost:
+		eventType = EventCreated
+	case OpPatch, OpRestore:
+		eventType = EventUpdated
+	case OpDelete:
+		eventType = EventDeleted
+	default:
+		return nil
+	}
+
+	object := entry.After
+	if object == nil {
+		object = entry.Before
+	}
+
+	for _, listener := range res.listeners {
+		err := listener.Publish(ctx, &ChangeEvent{
+			Type:         eventType,
+			ResourceType: entry.ResourceType,
+			ResourceID:   entry.ResourceID,
+			Object:       object,
+			resource:     res,
+		})
+		if err != nil {
+			return jsh.ISE(fmt.Sprintf("Error publishing change: %s", err.Error()))
+		}
+	}
+
+	return nil
+}
+
+// visible returns the payload of event for the subscriber identified by ctx, or
+// false if they may not see the object
+func (res *Resource) visible(ctx context.Context, event *ChangeEvent) (interface{}, bool) {
+	if res.authorization(ctx, OpGet, Target{ID: event.ResourceID}) != nil {
+		return nil, false
+	}
+
+	if event.Object != nil && res.tenantRead(ctx, event.Object) != nil {
+		return nil, false
+	}
+
+	if res.tenantAttribute != "" && event.Object == nil {
+		return nil, false
+	}
+
+	if event.Type == EventDeleted {
+		return eventIdentifier{Type: event.ResourceType, ID: event.ResourceID}, true
+	}
+
+	return res.redact(ctx, event.Object), true
+}
@@ -0,0 +1,154 @@
+package jshapi
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler/client"
+	"github.com/derekdowling/jsh-api/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// sseEvent is a single event read from a stream
+type sseEvent struct {
+	id        string
+	eventType string
+	data      string
+}
+
+// readEvent reads the next event from a stream, skipping comments
+func readEvent(reader *bufio.Reader) sseEvent {
+	event := sseEvent{}
+
+	for {
+		line, err := reader.ReadString('\n')
+		So(err, ShouldBeNil)
+
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event.id != "":
+			return event
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+
+	Convey("Change Feed Tests", t, func() {
+
+		resource := NewCRUDResource(testResourceType, store.NewMemory(testResourceType))
+		resource.Events()
+
+		api := New("")
+		api.Add(resource)
+		api.Events()
+
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		subscribe := func(url string, lastEventID string) (*bufio.Reader, func()) {
+			request, err := http.NewRequest("GET", url, nil)
+			So(err, ShouldBeNil)
+			if lastEventID != "" {
+				request.Header.Set("Last-Event-ID", lastEventID)
+			}
+
+			resp, err := http.DefaultClient.Do(request)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+
+			return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+		}
+
+		Convey("should stream changes made via the resource's handlers", func() {
+			stream, closeStream := subscribe(server.URL+"/bars/events", "")
+			defer closeStream()
+
+			_, resp, err := jsc.Post(server.URL, sampleObject("", testResourceType, testObjAttrs))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+
+			_, resp, err = jsc.Patch(server.URL, sampleObject("1", testResourceType, map[string]string{"foo": "baz"}))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			request, err := jsc.DeleteRequest(server.URL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			resp, err = http.DefaultClient.Do(request)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+
+			created := readEvent(stream)
+			So(created.id, ShouldEqual, "1")
+			So(created.eventType, ShouldEqual, EventCreated)
+			So(created.data, ShouldContainSubstring, `"attributes"`)
+
+			updated := readEvent(stream)
+			So(updated.eventType, ShouldEqual, EventUpdated)
+			So(updated.data, ShouldContainSubstring, "baz")
+
+			deleted := readEvent(stream)
+			So(deleted.eventType, ShouldEqual, EventDeleted)
+			So(deleted.data, ShouldEqual, `{"data":{"type":"bars","id":"1"}}`)
+
+			Convey("should resume from Last-Event-ID", func() {
+				resumed, closeResumed := subscribe(server.URL+"/bars/events", "1")
+				defer closeResumed()
+
+				So(readEvent(resumed).id, ShouldEqual, "2")
+				So(readEvent(resumed).id, ShouldEqual, "3")
+			})
+
+			Convey("should stream changes of every resource from the API", func() {
+				resumed, closeResumed := subscribe(server.URL+"/events", "0")
+				defer closeResumed()
+
+				event := readEvent(resumed)
+				So(event.eventType, ShouldEqual, EventCreated)
+			})
+		})
+	})
+}
+
+func TestChangeFeed(t *testing.T) {
+
+	Convey("ChangeFeed Tests", t, func() {
+
+		feed := NewChangeFeed()
+		feed.Buffer = 2
+		feed.SubscriberBuffer = 1
+
+		for i := 0; i < 3; i++ {
+			feed.Publish(context.Background(), &ChangeEvent{Type: EventCreated})
+		}
+
+		Convey("should only keep the most recent events", func() {
+			_, missed := feed.subscribe(0, true)
+			So(missed, ShouldHaveLength, 2)
+			So(missed[0].ID, ShouldEqual, 2)
+			So(missed[1].ID, ShouldEqual, 3)
+		})
+
+		Convey("should drop subscribers that fall behind", func() {
+			sub, _ := feed.subscribe(0, false)
+
+			feed.Publish(context.Background(), &ChangeEvent{Type: EventUpdated})
+			feed.Publish(context.Background(), &ChangeEvent{Type: EventUpdated})
+
+			_, open := <-sub.dropped
+			So(open, ShouldBeFalse)
+			So(len(sub.events), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,324 @@
+package jshapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+// IdempotencyHeader is the request header clients send a unique key with
+const IdempotencyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyTTL is how long responses are kept for replay by default
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// perRequestHeaders describe the request being answered rather than the recorded
+// response, so they are left out of replays
+var perRequestHeaders = []string{
+	RequestIDHeader,
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+	"Retry-After",
+}
+
+// IdempotentResponse is a recorded response that can be replayed
+type IdempotentResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// IdempotencyRecord tracks a request made with an idempotency key
+type IdempotencyRecord struct {
+	// Fingerprint identifies the request body the key was first used with
+	Fingerprint string
+	// Response is nil while the original request is still in flight
+	Response *IdempotentResponse
+}
+
+/*
+IdempotencyStore keeps track of idempotency keys. Implement it to share keys
+between multiple instances of an API, i.e. using Redis.
+*/
+type IdempotencyStore interface {
+	// Reserve marks key as in flight for the request identified by fingerprint.
+	// If key is already known the existing record is returned instead.
+	Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error)
+	// Complete stores the response for a reserved key
+	Complete(ctx context.Context, key string, response *IdempotentResponse, ttl time.Duration) error
+	// Release forgets a reserved key, allowing the request to be retried
+	Release(ctx context.Context, key string) error
+}
+
+// MemoryIdempotencyStore keeps idempotency keys in memory for a single API instance
+type MemoryIdempotencyStore struct {
+	mutex   sync.Mutex
+	records map[string]*memoryIdempotencyRecord
+	// Now returns the current time, overridable for tests
+	Now func() time.Time
+}
+
+// memoryIdempotencyRecord is a record along with when it expires
+type memoryIdempotencyRecord struct {
+	IdempotencyRecord
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore creates an empty in-memory store
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records: map[string]*memoryIdempotencyRecord{},
+		Now:     time.Now,
+	}
+}
+
+// Reserve implements IdempotencyStore
+func (m *MemoryIdempotencyStore) Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.Now()
+	m.expire(now)
+
+	existing, exists := m.records[key]
+	if exists {
+		record := existing.IdempotencyRecord
+		return &record, nil
+	}
+
+	m.records[key] = &memoryIdempotencyRecord{
+		IdempotencyRecord: IdempotencyRecord{Fingerprint: fingerprint},
+		expires:           now.Add(ttl),
+	}
+
+	return nil, nil
+}
+
+// Complete implements IdempotencyStore
+func (m *MemoryIdempotencyStore) Complete(ctx context.Context, key string, response *IdempotentResponse, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existing, exists := m.records[key]
+	if !exists {
+		return fmt.Errorf("idempotency key '%s' was not reserved", key)
+	}
+
+	existing.Response = response
+	existing.expires = m.Now().Add(ttl)
+	return nil
+}
+
+// Release implements IdempotencyStore
+func (m *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.records, key)
+	return nil
+}
+
+// expire forgets records past their TTL
+func (m *MemoryIdempotencyStore) expire(now time.Time) {
+	for key, record := range m.records {
+		if now.After(record.expires) {
+			delete(m.records, key)
+		}
+	}
+}
+
+/*
+Idempotency is a middleware that makes retrying `POST` requests and custom actions
+safe. The response to a request sent with an Idempotency-Key header is recorded,
+and replayed for any retry using the same key:
+
+	api.Use(auth.Middleware)
+	api.Use(jshapi.NewIdempotency().Middleware)
+
+Keys are scoped to the authenticated principal and the request's route. A retry
+sent while the original is still in flight is rejected with a 409, and reusing a
+key with a different request body is rejected with a 422. Responses with a 5XX
+status are not recorded so that the request can be retried.
+*/
+type Idempotency struct {
+	Store IdempotencyStore
+	// TTL is how long a recorded response is kept for replay, defaulting to
+	// DefaultIdempotencyTTL
+	TTL time.Duration
+	// MaxBytes is the largest request body that will be fingerprinted, defaulting
+	// to DefaultBodyLimits
+	MaxBytes int64
+}
+
+// NewIdempotency creates an Idempotency middleware using a MemoryIdempotencyStore
+func NewIdempotency() *Idempotency {
+	return &Idempotency{
+		Store:    NewMemoryIdempotencyStore(),
+		TTL:      DefaultIdempotencyTTL,
+		MaxBytes: DefaultBodyLimits.MaxBytes,
+	}
+}
+
+// Middleware can be registered via API.Use() or Resource.Use()
+func (i *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		idempotencyKey := r.Header.Get(IdempotencyHeader)
+		if idempotencyKey == "" || !idempotent(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		fingerprint, limitErr := i.fingerprint(r)
+		if limitErr != nil {
+			SendHandler(ctx, w, r, limitErr)
+			return
+		}
+
+		key := idempotencyScope(r, idempotencyKey)
+
+		record, err := i.Store.Reserve(ctx, key, fingerprint, i.ttl())
+		if err != nil {
+			SendHandler(ctx, w, r, jsh.ISE(fmt.Sprintf("Error reserving idempotency key: %s", err.Error())))
+			return
+		}
+
+		if record != nil {
+			i.replay(w, r, record, fingerprint)
+			return
+		}
+
+		// the response may already have been sent, so at least make sure the key
+		// isn't left in flight if it can't be completed, or the handler panics
+		completed := false
+		defer func() {
+			if !completed {
+				i.Store.Release(context.WithoutCancel(ctx), key)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: w, body: &bytes.Buffer{}}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.Status() < 500 {
+			err = i.Store.Complete(ctx, key, &IdempotentResponse{
+				Status: recorder.Status(),
+				Header: replayHeader(w.Header()),
+				Body:   recorder.body.Bytes(),
+			}, i.ttl())
+			completed = err == nil
+		}
+	})
+}
+
+// replayHeader copies the headers of a response that can be replayed
+func replayHeader(header http.Header) http.Header {
+	replayed := header.Clone()
+	for _, name := range perRequestHeaders {
+		replayed.Del(name)
+	}
+
+	return replayed
+}
+
+// replay sends the recorded response for a retried request
+func (i *Idempotency) replay(w http.ResponseWriter, r *http.Request, record *IdempotencyRecord, fingerprint string) {
+	ctx := r.Context()
+
+	if record.Fingerprint != fingerprint {
+		reuseErr := &jsh.Error{
+			Title:  "Idempotency Key Reused",
+			Detail: fmt.Sprintf("%s has already been used for a different request", IdempotencyHeader),
+			Status: http.StatusUnprocessableEntity,
+		}
+		reuseErr.Source.Pointer = "/data"
+		SendHandler(ctx, w, r, reuseErr)
+		return
+	}
+
+	if record.Response == nil {
+		SendHandler(ctx, w, r, &jsh.Error{
+			Title:  "Request In Progress",
+			Detail: fmt.Sprintf("A request with this %s is still being processed", IdempotencyHeader),
+			Status: http.StatusConflict,
+		})
+		return
+	}
+
+	header := w.Header()
+	for name, values := range record.Response.Header {
+		header[name] = values
+	}
+	header.Set("Idempotent-Replayed", "true")
+
+	w.WriteHeader(record.Response.Status)
+	w.Write(record.Response.Body)
+}
+
+// fingerprint hashes the request body, restoring it for the handler
+func (i *Idempotency) fingerprint(r *http.Request) (string, *jsh.Error) {
+	hash := sha256.New()
+	if r.Body == nil || r.Body == http.NoBody {
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	maxBytes := i.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultBodyLimits.MaxBytes
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", jsh.ISE(fmt.Sprintf("Error reading request body: %s", err.Error()))
+	}
+
+	if int64(len(body)) > maxBytes {
+		return "", PayloadTooLarge(fmt.Sprintf("Request body can not exceed %d bytes", maxBytes))
+	}
+
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ttl is how long responses are recorded for
+func (i *Idempotency) ttl() time.Duration {
+	if i.TTL == 0 {
+		return DefaultIdempotencyTTL
+	}
+
+	return i.TTL
+}
+
+// idempotent checks whether a request is a `POST` or a custom action
+func idempotent(r *http.Request) bool {
+	if r.Method == post {
+		return true
+	}
+
+	route, routed := RouteFromContext(r.Context())
+	return routed && strings.HasPrefix(string(route.Operation), string(ActionOperation("")))
+}
+
+// idempotencyScope scopes a key to the caller and route it was sent with
+func idempotencyScope(r *http.Request, key string) string {
+	caller := ""
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		caller = principal.ID
+	}
+
+	return fmt.Sprintf("%s|%s %s|%s", caller, r.Method, r.URL.Path, key)
+}
@@ -0,0 +1,147 @@
+package jshapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIdempotency(t *testing.T) {
+
+	saves := 0
+
+	resource := NewResource(testResourceType)
+	resource.Post(func(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+		saves++
+		object.ID = "1"
+		return object, nil
+	})
+
+	requests := 0
+
+	api := New("")
+	api.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set(RequestIDHeader, strconv.Itoa(requests))
+			next.ServeHTTP(w, r)
+		})
+	})
+	api.Use(NewIdempotency().Middleware)
+	api.Add(resource)
+
+	server := httptest.NewServer(api)
+	baseURL := server.URL
+
+	post := func(key string, attrs interface{}) (*jsh.Document, *http.Response) {
+		request, err := jsc.PostRequest(baseURL, sampleObject("", testResourceType, attrs))
+		So(err, ShouldBeNil)
+		request.Header.Set(IdempotencyHeader, key)
+
+		doc, resp, err := jsc.Do(request, jsh.ObjectMode)
+		So(err, ShouldBeNil)
+		return doc, resp
+	}
+
+	Convey("Idempotency Tests", t, func() {
+
+		Convey("should replay responses for retries", func() {
+			_, resp := post("first", testObjAttrs)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+			So(saves, ShouldEqual, 1)
+
+			doc, resp := post("first", testObjAttrs)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+			So(resp.Header.Get("Idempotent-Replayed"), ShouldEqual, "true")
+			So(resp.Header.Get(RequestIDHeader), ShouldEqual, strconv.Itoa(requests))
+			So(doc.Data[0].ID, ShouldEqual, "1")
+			So(saves, ShouldEqual, 1)
+
+			Convey("should reject reuse with a different body", func() {
+				doc, resp := post("first", map[string]string{"foo": "baz"})
+				So(resp.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+				So(doc.Errors[0].Title, ShouldEqual, "Idempotency Key Reused")
+			})
+		})
+
+		Convey("should default zero limits", func() {
+			recorded := 0
+			idempotency := &Idempotency{Store: NewMemoryIdempotencyStore()}
+			handler := idempotency.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				recorded++
+				w.WriteHeader(http.StatusCreated)
+			}))
+
+			for i := 0; i < 2; i++ {
+				request := httptest.NewRequest(http.MethodPost, "/"+testResourceType, strings.NewReader(`{"data": {}}`))
+				request.Header.Set(IdempotencyHeader, "defaults")
+
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, request)
+				So(recorder.Code, ShouldEqual, http.StatusCreated)
+			}
+
+			So(recorded, ShouldEqual, 1)
+		})
+
+		Convey("should release keys when the handler panics", func() {
+			idempotency := NewIdempotency()
+			handler := idempotency.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("storage failure")
+			}))
+
+			request := httptest.NewRequest(http.MethodPost, "/"+testResourceType, nil)
+			request.Header.Set(IdempotencyHeader, "panics")
+			So(func() { handler.ServeHTTP(httptest.NewRecorder(), request) }, ShouldPanic)
+
+			key := idempotencyScope(request, "panics")
+			record, err := idempotency.Store.Reserve(context.Background(), key, "", DefaultIdempotencyTTL)
+			So(err, ShouldBeNil)
+			So(record, ShouldBeNil)
+		})
+	})
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+
+	Convey("MemoryIdempotencyStore Tests", t, func() {
+
+		store := NewMemoryIdempotencyStore()
+		ctx := context.Background()
+
+		record, err := store.Reserve(ctx, "key", "body", DefaultIdempotencyTTL)
+		So(err, ShouldBeNil)
+		So(record, ShouldBeNil)
+
+		Convey("should report in flight requests", func() {
+			record, err := store.Reserve(ctx, "key", "body", DefaultIdempotencyTTL)
+			So(err, ShouldBeNil)
+			So(record.Fingerprint, ShouldEqual, "body")
+			So(record.Response, ShouldBeNil)
+		})
+
+		Convey("should return completed responses", func() {
+			err := store.Complete(ctx, "key", &IdempotentResponse{Status: http.StatusCreated}, DefaultIdempotencyTTL)
+			So(err, ShouldBeNil)
+
+			record, err := store.Reserve(ctx, "key", "body", DefaultIdempotencyTTL)
+			So(err, ShouldBeNil)
+			So(record.Response.Status, ShouldEqual, http.StatusCreated)
+		})
+
+		Convey("should forget released keys", func() {
+			So(store.Release(ctx, "key"), ShouldBeNil)
+
+			record, err := store.Reserve(ctx, "key", "body", DefaultIdempotencyTTL)
+			So(err, ShouldBeNil)
+			So(record, ShouldBeNil)
+		})
+	})
+}
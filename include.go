@@ -0,0 +1,375 @@
+package jshapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/jsh-api/store"
+)
+
+// IncludeParam is the query parameter listing the relationships to include with
+// a `GET /resource` or `GET /resource/:id` response
+const IncludeParam = "include"
+
+/*
+include loads a relationship registered with ToOne, ToMany, BatchToOne, or
+BatchToMany for many parent objects at once. Relationships registered without
+batch storage fall back to calling their storage once per parent.
+*/
+type include struct {
+	name      string
+	operation Operation
+	load      func(ctx context.Context, ids []string) (map[string]jsh.List, error)
+}
+
+/*
+BatchToOne registers a ToOne relationship using storage that loads the related
+objects of many parents in a single call. Besides serving the relationship routes,
+the batch is used for `?include=<resourceType>`, so that including the relationship
+for a list of objects only calls storage once:
+
+	posts.BatchToOne("author", func(ctx context.Context, postIDs []string) (map[string]*jsh.Object, error) {
+		// SELECT ... FROM users JOIN posts ... WHERE posts.id IN (postIDs)
+	})
+*/
+func (res *Resource) BatchToOne(resourceType string, storage store.BatchToOne) {
+	res.ToOne(resourceType, func(ctx context.Context, id string) (*jsh.Object, error) {
+		related, err := storage(ctx, []string{id})
+		if normalizeError(err) != nil {
+			return nil, err
+		}
+
+		object, exists := related[id]
+		if !exists || object == nil {
+			return nil, jsh.NotFound(toOneName(resourceType), id)
+		}
+
+		return object, nil
+	})
+
+	res.includable[toOneName(resourceType)].load = func(ctx context.Context, ids []string) (map[string]jsh.List, error) {
+		related, err := storage(ctx, ids)
+		if normalizeError(err) != nil {
+			return nil, err
+		}
+
+		lists := map[string]jsh.List{}
+		for id, object := range related {
+			if object != nil {
+				lists[id] = jsh.List{object}
+			}
+		}
+
+		return lists, nil
+	}
+}
+
+// BatchToMany registers a ToMany relationship using storage that loads the related
+// objects of many parents in a single call, in the same manner as BatchToOne
+func (res *Resource) BatchToMany(resourceType string, storage store.BatchToMany) {
+	res.ToMany(resourceType, func(ctx context.Context, id string) (jsh.List, error) {
+		related, err := storage(ctx, []string{id})
+		if normalizeError(err) != nil {
+			return nil, err
+		}
+
+		list := related[id]
+		if list == nil {
+			list = jsh.List{}
+		}
+
+		return list, nil
+	})
+
+	res.includable[toManyName(resourceType)].load = storage
+}
+
+// toOneInclude loads a ToOne relationship by calling storage for each parent,
+// treating parents whose related object isn't found as having none
+func toOneInclude(storage store.Get) func(context.Context, []string) (map[string]jsh.List, error) {
+	return func(ctx context.Context, ids []string) (map[string]jsh.List, error) {
+		lists := map[string]jsh.List{}
+
+		for _, id := range ids {
+			object, err := storage(ctx, id)
+			if isNotFound(err) {
+				continue
+			}
+			if normalizeError(err) != nil {
+				return nil, err
+			}
+
+			if object != nil {
+				lists[id] = jsh.List{object}
+			}
+		}
+
+		return lists, nil
+	}
+}
+
+// toManyInclude loads a ToMany relationship by calling storage for each parent
+func toManyInclude(storage store.ToMany) func(context.Context, []string) (map[string]jsh.List, error) {
+	return func(ctx context.Context, ids []string) (map[string]jsh.List, error) {
+		lists := map[string]jsh.List{}
+
+		for _, id := range ids {
+			list, err := storage(ctx, id)
+			if normalizeError(err) != nil {
+				return nil, err
+			}
+
+			lists[id] = list
+		}
+
+		return lists, nil
+	}
+}
+
+// isNotFound checks whether storage reported a missing object
+func isNotFound(err error) bool {
+	notFound, isError := normalizeError(err).(*jsh.Error)
+	return isError && notFound.Status == http.StatusNotFound
+}
+
+// includes parses the relationships requested by `?include=`, rejecting any the
+// resource doesn't have. Nested relationship paths aren't supported.
+func (res *Resource) includes(r *http.Request) ([]*include, jsh.ErrorType) {
+	param := r.URL.Query().Get(IncludeParam)
+	if param == "" {
+		return nil, nil
+	}
+
+	includes := []*include{}
+	requested := map[string]bool{}
+
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if requested[name] {
+			continue
+		}
+
+		relationship, exists := res.includable[name]
+		if !exists {
+			return nil, &jsh.Error{
+				Title:  "Invalid Include",
+				Detail: fmt.Sprintf("'%s' is not a relationship of '%s'", name, res.Type),
+				Status: http.StatusBadRequest,
+			}
+		}
+
+		requested[name] = true
+		includes = append(includes, relationship)
+	}
+
+	return includes, nil
+}
+
+/*
+sendIncluded sends objects along with their included relationships. Each
+relationship is authorized like its own route, for every object, before a loader
+collects the objects' ids and loads each relationship with one batch, resolving
+the different relationships concurrently. Related objects are then filtered as if
+they had been fetched from their own resource.
+*/
+func (res *Resource) sendIncluded(w http.ResponseWriter, r *http.Request, sendable jsh.Sendable, includes []*include) {
+	ctx := r.Context()
+
+	// validating sets the response status, as jsh.Send would
+	validationErr := sendable.Validate(r, true)
+	if validationErr != nil {
+		SendHandler(ctx, w, r, validationErr)
+		return
+	}
+	document := jsh.Build(sendable)
+
+	loader := newIncludeLoader()
+	for _, object := range document.Data {
+		for _, relationship := range includes {
+			authErr := res.policy(ctx, relationship.operation, Target{ID: object.ID})
+			if authErr != nil {
+				SendHandler(ctx, w, r, authErr)
+				return
+			}
+
+			loader.load(relationship, object.ID)
+		}
+	}
+
+	related, loadErr := loader.dispatch(ctx, res)
+	if loadErr != nil {
+		SendHandler(ctx, w, r, loadErr)
+		return
+	}
+
+	primary := map[string]bool{}
+	for _, object := range document.Data {
+		primary[identify(object)] = true
+	}
+
+	data := make(jsh.List, 0, len(document.Data))
+	included := map[string]bool{}
+	visible := map[string]*jsh.Object{}
+	for _, object := range document.Data {
+		// storage may still be holding on to the object
+		linked := *object
+		linked.Relationships = map[string]*jsh.Relationship{}
+		for name, relationship := range object.Relationships {
+			linked.Relationships[name] = relationship
+		}
+
+		for _, relationship := range includes {
+			linkage := jsh.ResourceLinkage{}
+
+			for _, relatedObject := range related[relationship.name][object.ID] {
+				key := identify(relatedObject)
+				if _, checked := visible[key]; !checked {
+					visible[key] = res.includedObject(ctx, relatedObject)
+				}
+
+				relatedObject = visible[key]
+				if relatedObject == nil {
+					continue
+				}
+
+				linkage = append(linkage, &jsh.ResourceIdentifier{
+					Type: relatedObject.Type,
+					ID:   relatedObject.ID,
+				})
+
+				if !primary[key] && !included[key] {
+					included[key] = true
+					document.Included = append(document.Included, relatedObject)
+				}
+			}
+
+			linked.Relationships[relationship.name] = &jsh.Relationship{Data: linkage}
+		}
+
+		data = append(data, &linked)
+	}
+	document.Data = data
+
+	res.send(w, r, document)
+}
+
+/*
+includedObject applies the policies, tenancy, soft deletes, and attribute
+permissions of the API resource serving a related object's type, returning nil if
+the caller may not get the object. Objects of types the API doesn't serve are
+included as they are.
+*/
+func (res *Resource) includedObject(ctx context.Context, object *jsh.Object) *jsh.Object {
+	related := res.resources[object.Type]
+	if object.Type == res.Type {
+		related = res
+	}
+
+	if related == nil {
+		return object
+	}
+
+	if related.authorization(ctx, OpGet, Target{ID: object.ID}) != nil {
+		return nil
+	}
+
+	if related.tenantRead(ctx, object) != nil || related.deletedRead(object) != nil {
+		return nil
+	}
+
+	return related.redact(ctx, object)
+}
+
+// identify keys an object by type and id
+func identify(object *jsh.Object) string {
+	return object.Type + "\x00" + object.ID
+}
+
+/*
+includeLoader is created for each request to collect the parent ids each
+relationship needs to be loaded for while a document is assembled, so that every
+relationship is loaded with a single storage call once dispatched.
+*/
+type includeLoader struct {
+	relationships []*include
+	ids           map[*include][]string
+	queued        map[*include]map[string]bool
+}
+
+// newIncludeLoader creates an empty includeLoader
+func newIncludeLoader() *includeLoader {
+	return &includeLoader{
+		ids:    map[*include][]string{},
+		queued: map[*include]map[string]bool{},
+	}
+}
+
+// load queues a relationship to be loaded for a parent id
+func (l *includeLoader) load(relationship *include, id string) {
+	if l.queued[relationship] == nil {
+		l.relationships = append(l.relationships, relationship)
+		l.queued[relationship] = map[string]bool{}
+	}
+
+	if !l.queued[relationship][id] {
+		l.queued[relationship][id] = true
+		l.ids[relationship] = append(l.ids[relationship], id)
+	}
+}
+
+/*
+dispatch loads every queued relationship concurrently, returning the related
+objects by relationship name and parent id. The first relationship to fail, in the
+order they were requested, decides the error returned. Storage panics are carried
+over to the request's goroutine once every relationship has finished loading.
+*/
+func (l *includeLoader) dispatch(ctx context.Context, res *Resource) (map[string]map[string]jsh.List, jsh.ErrorType) {
+	results := make([]map[string]jsh.List, len(l.relationships))
+	errors := make([]jsh.ErrorType, len(l.relationships))
+	panics := make([]interface{}, len(l.relationships))
+
+	var wait sync.WaitGroup
+	for index, relationship := range l.relationships {
+		wait.Add(1)
+
+		go func(index int, relationship *include) {
+			defer wait.Done()
+			defer func() {
+				panics[index] = recover()
+			}()
+
+			includeCtx, span := res.startSpan(ctx, "jshapi.include", "")
+			span.SetAttribute(AttrRelationship, relationship.name)
+			defer span.End()
+
+			storageCtx, observe := res.instrument(includeCtx, "")
+			related, err := relationship.load(storageCtx, l.ids[relationship])
+			observe(err)
+
+			errors[index] = res.storageError(err, "")
+			results[index] = related
+		}(index, relationship)
+	}
+	wait.Wait()
+
+	for _, recovered := range panics {
+		if recovered != nil {
+			panic(recovered)
+		}
+	}
+
+	related := map[string]map[string]jsh.List{}
+	for index, relationship := range l.relationships {
+		if errors[index] != nil {
+			return nil, errors[index]
+		}
+
+		related[relationship.name] = results[index]
+	}
+
+	return related, nil
+}
@@ -0,0 +1,153 @@
+package jshapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	"github.com/derekdowling/jsh-api/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInclude(t *testing.T) {
+
+	Convey("Include Tests", t, func() {
+
+		recorder := NewSpanRecorder()
+
+		authors := map[string]string{"1": "a", "2": "a", "3": "b"}
+		authorBatches := [][]string{}
+		commentCalls := 0
+
+		posts := NewCRUDResource("posts", store.NewMemory("posts"))
+		posts.BatchToOne("author", func(ctx context.Context, ids []string) (map[string]*jsh.Object, error) {
+			authorBatches = append(authorBatches, ids)
+
+			related := map[string]*jsh.Object{}
+			for _, id := range ids {
+				if author, exists := authors[id]; exists {
+					related[id] = sampleObject(author, "users", testObjAttrs)
+				}
+			}
+
+			return related, nil
+		})
+		posts.ToMany("comment", func(ctx context.Context, id string) (jsh.List, error) {
+			commentCalls++
+			return jsh.List{sampleObject("c"+id, "comments", testObjAttrs)}, nil
+		})
+
+		api := New("")
+		api.Use(NewTracing(recorder).Middleware)
+		api.Add(posts)
+
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for i := 0; i < 3; i++ {
+			_, resp, err := jsc.Post(server.URL, sampleObject("", "posts", testObjAttrs))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+		}
+
+		include := func(request *http.Request, mode jsh.DocumentMode, relationships string) (*jsh.Document, *http.Response) {
+			query := request.URL.Query()
+			query.Set(IncludeParam, relationships)
+			request.URL.RawQuery = query.Encode()
+
+			doc, resp, err := jsc.Do(request, mode)
+			So(err, ShouldBeNil)
+			return doc, resp
+		}
+
+		Convey("should load each relationship of a list with one batch", func() {
+			recorder.Reset()
+
+			request, err := jsc.ListRequest(server.URL, "posts")
+			So(err, ShouldBeNil)
+
+			doc, resp := include(request, jsh.ListMode, "author,comments")
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(doc.Data, ShouldHaveLength, 3)
+
+			So(authorBatches, ShouldHaveLength, 1)
+			So(authorBatches[0], ShouldResemble, []string{"1", "2", "3"})
+			So(commentCalls, ShouldEqual, 3)
+
+			So(doc.Data[1].Relationships["author"].Data[0].ID, ShouldEqual, "a")
+			So(doc.Data[2].Relationships["comments"].Data[0].ID, ShouldEqual, "c3")
+
+			// both posts by "a" share a single included author
+			So(doc.Included, ShouldHaveLength, 5)
+
+			spans := recorder.Named("jshapi.include")
+			So(spans, ShouldHaveLength, 2)
+			So(recorder.Named("jshapi.storage"), ShouldHaveLength, 3)
+		})
+
+		Convey("should include relationships of a single object", func() {
+			request, err := jsc.FetchRequest(server.URL, "posts", "3")
+			So(err, ShouldBeNil)
+
+			doc, resp := include(request, jsh.ObjectMode, "author")
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(doc.Included, ShouldHaveLength, 1)
+			So(doc.Included[0].ID, ShouldEqual, "b")
+		})
+
+		Convey("should filter included objects by the policies of their resource", func() {
+			users := NewResource("users")
+			users.Authorize(func(ctx context.Context, principal *Principal, operation Operation, target Target) error {
+				if target.ID == "b" {
+					return errors.New("hidden")
+				}
+				return nil
+			}, OpGet)
+			users.AttributePolicy("foo", AttributePolicy{
+				Read: func(ctx context.Context, principal *Principal, object *jsh.Object) bool {
+					return false
+				},
+			})
+			api.Add(users)
+
+			request, err := jsc.ListRequest(server.URL, "posts")
+			So(err, ShouldBeNil)
+
+			doc, resp := include(request, jsh.ListMode, "author")
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(doc.Included, ShouldHaveLength, 1)
+			So(doc.Included[0].ID, ShouldEqual, "a")
+			So(string(doc.Included[0].Attributes), ShouldNotContainSubstring, "foo")
+			So(doc.Data[2].Relationships["author"].Data, ShouldBeEmpty)
+		})
+
+		Convey("should carry storage panics over to the request", func() {
+			posts.ToMany("panic", func(ctx context.Context, id string) (jsh.List, error) {
+				panic("storage failure")
+			})
+
+			request := httptest.NewRequest(http.MethodGet, "/posts?"+IncludeParam+"=panics", nil)
+			So(func() { posts.ServeHTTP(httptest.NewRecorder(), request) }, ShouldPanicWith, "storage failure")
+		})
+
+		Convey("should reject unknown relationships", func() {
+			request, err := jsc.ListRequest(server.URL, "posts")
+			So(err, ShouldBeNil)
+
+			_, resp := include(request, jsh.ListMode, "editor")
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(authorBatches, ShouldBeEmpty)
+		})
+
+		Convey("should still serve batch relationships by route", func() {
+			resp, err := http.Get(server.URL + "/posts/1/author")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(authorBatches, ShouldResemble, [][]string{{"1"}})
+		})
+	})
+}
@@ -0,0 +1,438 @@
+package jshapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/jsh-api/store"
+)
+
+const (
+	// JobType is the resource type that job statuses are served under
+	JobType = "jobs"
+	// DefaultJobWorkers is the number of workers used by an API's JobQueue if one
+	// isn't set before adding an asynchronous resource
+	DefaultJobWorkers = 4
+	// DefaultJobBacklog is the number of pending jobs that can wait for a worker
+	// before new jobs are rejected
+	DefaultJobBacklog = 100
+	// DefaultJobRetention is how long finished jobs remain available
+	DefaultJobRetention = time.Hour
+)
+
+// JobStatus is the current state of a background job
+type JobStatus string
+
+const (
+	// JobPending signifies a job waiting for an available worker
+	JobPending JobStatus = "pending"
+	// JobRunning signifies a job currently being processed
+	JobRunning JobStatus = "running"
+	// JobComplete signifies a job that finished successfully
+	JobComplete JobStatus = "complete"
+	// JobFailed signifies a job that returned an error
+	JobFailed JobStatus = "failed"
+)
+
+/*
+JobQueue runs pending storage jobs on a bounded pool of workers, and tracks their
+progress so that clients can poll `GET /jobs/:id` until the job is done. Once a
+job completes, the job endpoint responds with a "303 See Other" redirecting to the
+resulting resource.
+
+An API creates a JobQueue automatically the first time an asynchronous resource is
+added. To customize it, set API.Jobs beforehand:
+
+	api := jshapi.New("")
+	api.Jobs = jshapi.NewJobQueue(10, 1000)
+	api.Add(resource)
+*/
+type JobQueue struct {
+	// Retention is how long finished jobs can still be fetched
+	Retention time.Duration
+	// prefix is the API prefix used for building job and resource locations
+	prefix  string
+	errors  *ErrorMapper
+	pending chan *job
+	mutex   sync.RWMutex
+	jobs    map[string]*job
+	closed  bool
+}
+
+// job holds the state of a single unit of background work
+type job struct {
+	ID      string
+	Status  JobStatus
+	Created time.Time
+	Updated time.Time
+	ctx     context.Context
+	work    store.Job
+	result  *jsh.Object
+	err     jsh.ErrorType
+}
+
+// NewJobQueue starts a pool of workers that process up to backlog pending jobs
+func NewJobQueue(workers int, backlog int) *JobQueue {
+	queue := &JobQueue{
+		Retention: DefaultJobRetention,
+		prefix:    "/",
+		pending:   make(chan *job, backlog),
+		jobs:      map[string]*job{},
+	}
+
+	for i := 0; i < workers; i++ {
+		go queue.work()
+	}
+
+	return queue
+}
+
+/*
+Enqueue schedules a job to be run in the background and returns its current
+status as a "jobs" object. If the backlog is full, a 503 error is returned.
+
+The job is run with the values of ctx, such as its principal and tenant, but isn't
+cancelled along with it, and isn't part of the request's transaction.
+*/
+func (q *JobQueue) Enqueue(ctx context.Context, work store.Job) (*jsh.Object, jsh.ErrorType) {
+	id, idErr := newJobID()
+	if idErr != nil {
+		return nil, idErr
+	}
+
+	now := time.Now()
+	pending := &job{
+		ID:      id,
+		Status:  JobPending,
+		Created: now,
+		Updated: now,
+		ctx:     withoutTransaction(context.WithoutCancel(ctx)),
+		work:    work,
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return nil, jsh.ISE("Job queue has been closed")
+	}
+
+	q.expire(now)
+
+	select {
+	case q.pending <- pending:
+		q.jobs[id] = pending
+	default:
+		return nil, &jsh.Error{
+			Title:  "Service Unavailable",
+			Detail: "Too many pending jobs, try again later",
+			Status: http.StatusServiceUnavailable,
+		}
+	}
+
+	return q.object(pending)
+}
+
+// Get returns the current status of a job as a "jobs" object
+func (q *JobQueue) Get(ctx context.Context, id string) (*jsh.Object, jsh.ErrorType) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	existing, exists := q.jobs[id]
+	if !exists {
+		return nil, jsh.NotFound(JobType, id)
+	}
+
+	return q.object(existing)
+}
+
+// Close stops the queue's workers once all pending jobs are finished. Jobs can no
+// longer be enqueued afterwards.
+func (q *JobQueue) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.pending)
+	}
+}
+
+// Location returns the path of the job status endpoint for a job id
+func (q *JobQueue) Location(id string) string {
+	return path.Join(q.prefix, JobType, id)
+}
+
+// work processes pending jobs until the queue is closed
+func (q *JobQueue) work() {
+	for pending := range q.pending {
+		q.update(pending, JobRunning, nil, nil)
+
+		result, err := q.run(pending)
+		if err != nil {
+			q.update(pending, JobFailed, nil, err)
+			continue
+		}
+
+		q.update(pending, JobComplete, result, nil)
+	}
+}
+
+// run performs a single job, converting any panic into an ISE
+func (q *JobQueue) run(pending *job) (result *jsh.Object, err jsh.ErrorType) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result = nil
+			err = jsh.ISE(fmt.Sprintf("Job %s panicked: %v", pending.ID, recovered))
+		}
+	}()
+
+	object, workErr := pending.work(pending.ctx)
+	workErr = normalizeError(workErr)
+	if workErr == nil {
+		return object, nil
+	}
+
+	mapper := q.errors
+	if mapper == nil {
+		mapper = DefaultErrors
+	}
+
+	return nil, mapper.Map(workErr, "", "")
+}
+
+// update records a job's progress
+func (q *JobQueue) update(pending *job, status JobStatus, result *jsh.Object, err jsh.ErrorType) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	pending.Status = status
+	pending.Updated = time.Now()
+	pending.result = result
+	pending.err = err
+}
+
+// expire removes finished jobs that are past the retention period, must be
+// called while holding the write lock
+func (q *JobQueue) expire(now time.Time) {
+	for id, existing := range q.jobs {
+		finished := existing.Status == JobComplete || existing.Status == JobFailed
+		if finished && now.Sub(existing.Updated) > q.Retention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// object builds a JSON API representation of a job, must be called while holding
+// a lock
+func (q *JobQueue) object(existing *job) (*jsh.Object, jsh.ErrorType) {
+	attributes := map[string]interface{}{
+		"status":  existing.Status,
+		"created": existing.Created,
+		"updated": existing.Updated,
+	}
+
+	if existing.err != nil {
+		attributes["errors"] = jobErrors(existing.err)
+	}
+
+	object, err := jsh.NewObject(existing.ID, JobType, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	object.Links["self"] = &jsh.Link{HREF: q.Location(existing.ID)}
+
+	return object, nil
+}
+
+// result returns the location of the resource created by a completed job
+func (q *JobQueue) result(id string) (string, bool) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	existing, exists := q.jobs[id]
+	if !exists || existing.Status != JobComplete || existing.result == nil {
+		return "", false
+	}
+
+	return path.Join(q.prefix, existing.result.Type, existing.result.ID), true
+}
+
+/*
+NewJobResource builds the `GET /jobs/:id` resource for a JobQueue. This is done
+automatically by API.Add when a resource registers asynchronous handlers.
+*/
+func NewJobResource(queue *JobQueue) *Resource {
+	resource := NewResource(JobType)
+
+	resource.handle(
+		resource.pattern(get, patID),
+		OpGet,
+		func(w http.ResponseWriter, r *http.Request) {
+			resource.jobHandler(w, r, queue)
+		},
+	)
+
+	resource.addRoute(get, patID)
+
+	return resource
+}
+
+// PostAsync registers a `POST /resource` handler which can finish saving in the
+// background. While pending, the request is answered with "202 Accepted" and a
+// Content-Location header pointing at the job's status.
+func (res *Resource) PostAsync(storage store.SaveAsync) {
+	res.async = true
+
+	res.handle(
+		res.pattern(post, patRoot),
+		OpPost,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.asyncHandler(w, r, storage)
+		},
+	)
+
+	res.addRoute(post, patRoot)
+}
+
+// PatchAsync registers a `PATCH /resource/:id` handler which can finish updating
+// in the background, in the same manner as PostAsync
+func (res *Resource) PatchAsync(storage store.UpdateAsync) {
+	res.async = true
+
+	res.handle(
+		res.pattern(patch, patID),
+		OpPatch,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.asyncHandler(w, r, store.SaveAsync(storage))
+		},
+	)
+
+	res.addRoute(patch, patID)
+}
+
+// POST /resources and PATCH /resources/:id for asynchronous storage
+func (res *Resource) asyncHandler(w http.ResponseWriter, r *http.Request, storage store.SaveAsync) {
+	ctx := r.Context()
+
+	parsedObject, parseErr := jsh.ParseObject(r)
+	if parseErr != nil {
+		SendHandler(ctx, w, r, parseErr)
+		return
+	}
+
+	operation := OpPost
+	if r.Method == patch {
+		operation = OpPatch
+
+		id := r.PathValue("id")
+		if id != parsedObject.ID {
+			SendHandler(ctx, w, r, jsh.InputError("Request ID does not match URL's", "id"))
+			return
+		}
+	}
+
+	if !res.authorize(w, r, operation, Target{ID: parsedObject.ID, Object: parsedObject}) {
+		return
+	}
+
+	// new objects have nothing to snapshot
+	changed := []string{}
+	if operation == OpPatch {
+		changed = append(changed, parsedObject.ID)
+	}
+
+	mutation := res.observeMutation(ctx, operation, changed...)
+
+	storageCtx, observe := res.instrument(ctx, parsedObject.ID)
+	object, pending, err := storage(storageCtx, parsedObject)
+	observe(err)
+	sendableErr := res.storageError(err, parsedObject.ID)
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
+		return
+	}
+
+	if pending == nil {
+		recordErr := mutation.record(ctx, object)
+		if recordErr != nil {
+			SendHandler(ctx, w, r, recordErr)
+			return
+		}
+
+		res.send(w, r, object)
+		return
+	}
+
+	if res.jobs == nil {
+		SendHandler(ctx, w, r, jsh.ISE(fmt.Sprintf(
+			"Resource '%s' must be added to an API to process jobs", res.Type,
+		)))
+		return
+	}
+
+	status, enqueueErr := res.jobs.Enqueue(ctx, mutation.job(pending))
+	if enqueueErr != nil {
+		SendHandler(ctx, w, r, enqueueErr)
+		return
+	}
+
+	status.Status = http.StatusAccepted
+	w.Header().Set("Content-Location", res.jobs.Location(status.ID))
+	SendHandler(ctx, w, r, status)
+}
+
+// GET /jobs/:id
+func (res *Resource) jobHandler(w http.ResponseWriter, r *http.Request, queue *JobQueue) {
+	ctx := r.Context()
+
+	id := r.PathValue("id")
+
+	location, complete := queue.result(id)
+	if complete {
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+
+	status, err := queue.Get(ctx, id)
+	if err != nil {
+		SendHandler(ctx, w, r, err)
+		return
+	}
+
+	SendHandler(ctx, w, r, status)
+}
+
+// jobErrors converts the error a job failed with into a list that is safe to show
+// the client, internal error messages are not serialized
+func jobErrors(err jsh.ErrorType) jsh.ErrorList {
+	switch typedErr := err.(type) {
+	case *jsh.Error:
+		return jsh.ErrorList{typedErr}
+	case jsh.ErrorList:
+		return typedErr
+	}
+
+	return jsh.ErrorList{jsh.ISE(err.Error())}
+}
+
+// newJobID generates a random, unguessable job identifier
+func newJobID() (string, *jsh.Error) {
+	raw := make([]byte, 16)
+
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", jsh.ISE(fmt.Sprintf("Unable to generate job ID: %s", err.Error()))
+	}
+
+	return hex.EncodeToString(raw), nil
+}
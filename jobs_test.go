@@ -0,0 +1,203 @@
+package jshapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	"github.com/derekdowling/jsh-api/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJobs(t *testing.T) {
+
+	release := make(chan bool)
+
+	resource := NewResource(testResourceType)
+	resource.PostAsync(func(ctx context.Context, object *jsh.Object) (*jsh.Object, store.Job, error) {
+		job := func(ctx context.Context) (*jsh.Object, error) {
+			<-release
+			object.ID = "1"
+			return object, nil
+		}
+
+		return nil, job, nil
+	})
+
+	api := New("api")
+	api.Add(resource)
+
+	server := httptest.NewServer(api)
+	baseURL := server.URL + api.prefix
+
+	// don't follow the "303 See Other" so it can be inspected
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	Convey("Job Tests", t, func() {
+
+		Convey("API State", func() {
+			So(api.Jobs, ShouldNotBeNil)
+			So(api.Resources[JobType], ShouldNotBeNil)
+		})
+
+		Convey("->PostAsync()", func() {
+			object := sampleObject("", testResourceType, testObjAttrs)
+			doc, resp, err := jsc.Post(baseURL, object)
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusAccepted)
+			So(doc.Data[0].Type, ShouldEqual, JobType)
+
+			location := resp.Header.Get("Content-Location")
+			So(location, ShouldEqual, "/api/jobs/"+doc.Data[0].ID)
+
+			Convey("should report pending jobs", func() {
+				request, err := jsc.NewRequest("GET", server.URL+location, nil)
+				So(err, ShouldBeNil)
+
+				response, err := client.Do(request)
+				So(err, ShouldBeNil)
+				So(response.StatusCode, ShouldEqual, http.StatusOK)
+
+				release <- true
+
+				Convey("should redirect to the resource once complete", func() {
+					var response *http.Response
+					for attempt := 0; attempt < 50; attempt++ {
+						response, err = client.Do(request)
+						So(err, ShouldBeNil)
+
+						if response.StatusCode == http.StatusSeeOther {
+							break
+						}
+
+						time.Sleep(10 * time.Millisecond)
+					}
+
+					So(response.StatusCode, ShouldEqual, http.StatusSeeOther)
+					So(response.Header.Get("Location"), ShouldEqual, "/api/bars/1")
+				})
+			})
+		})
+
+		Convey("->Get()", func() {
+
+			Convey("should 404 for unknown jobs", func() {
+				_, resp, err := jsc.Fetch(baseURL, JobType, "missing")
+
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
+
+func TestJobQueue(t *testing.T) {
+
+	Convey("JobQueue Tests", t, func() {
+
+		Convey("should reject jobs once the backlog is full", func() {
+			queue := NewJobQueue(0, 1)
+			work := func(ctx context.Context) (*jsh.Object, error) {
+				return nil, nil
+			}
+
+			_, err := queue.Enqueue(context.Background(), work)
+			So(err, ShouldBeNil)
+
+			_, err = queue.Enqueue(context.Background(), work)
+			So(err, ShouldNotBeNil)
+			So(err.StatusCode(), ShouldEqual, http.StatusServiceUnavailable)
+		})
+
+		Convey("should record failed jobs", func() {
+			queue := NewJobQueue(1, 1)
+			defer queue.Close()
+
+			status, err := queue.Enqueue(context.Background(), func(ctx context.Context) (*jsh.Object, error) {
+				return nil, jsh.ISE("storage failure")
+			})
+			So(err, ShouldBeNil)
+
+			var current *jsh.Object
+			for attempt := 0; attempt < 50; attempt++ {
+				current, err = queue.Get(context.Background(), status.ID)
+				So(err, ShouldBeNil)
+
+				if strings.Contains(string(current.Attributes), string(JobFailed)) {
+					break
+				}
+
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			So(string(current.Attributes), ShouldContainSubstring, string(JobFailed))
+			So(string(current.Attributes), ShouldNotContainSubstring, "storage failure")
+		})
+
+		Convey("should run jobs with the values of the request's context", func() {
+			queue := NewJobQueue(1, 1)
+			defer queue.Close()
+
+			ctx, cancel := context.WithCancel(WithPrincipal(context.Background(), &Principal{ID: "1"}))
+			cancel()
+
+			seen := make(chan context.Context, 1)
+			_, err := queue.Enqueue(ctx, func(ctx context.Context) (*jsh.Object, error) {
+				seen <- ctx
+				return nil, nil
+			})
+			So(err, ShouldBeNil)
+
+			jobCtx := <-seen
+			So(jobCtx.Err(), ShouldBeNil)
+
+			principal, ok := PrincipalFromContext(jobCtx)
+			So(ok, ShouldBeTrue)
+			So(principal.ID, ShouldEqual, "1")
+		})
+
+		Convey("should run jobs outside of the request's transaction", func() {
+			queue := NewJobQueue(1, 1)
+			defer queue.Close()
+
+			storage := store.NewMemory(testResourceType)
+			tx, beginErr := storage.Begin(context.Background())
+			So(beginErr, ShouldBeNil)
+
+			ctx := context.WithValue(store.WithTx(context.Background(), tx), effectsKey, &sideEffects{})
+
+			joined := make(chan bool, 1)
+			saved := make(chan error, 1)
+			_, err := queue.Enqueue(ctx, func(ctx context.Context) (*jsh.Object, error) {
+				_, inTx := store.TxFromContext(ctx)
+				_, hasEffects := ctx.Value(effectsKey).(*sideEffects)
+				joined <- inTx || hasEffects
+
+				_, saveErr := storage.Save(ctx, sampleObject("", testResourceType, testObjAttrs))
+				saved <- saveErr
+				return nil, saveErr
+			})
+			So(err, ShouldBeNil)
+			So(<-joined, ShouldBeFalse)
+
+			// the job waits for the transaction rather than joining it
+			time.Sleep(20 * time.Millisecond)
+			So(tx.Rollback(context.Background()), ShouldBeNil)
+			So(<-saved, ShouldBeNil)
+
+			list, listErr := storage.List(context.Background())
+			So(listErr, ShouldBeNil)
+			So(list, ShouldHaveLength, 1)
+		})
+	})
+}
@@ -0,0 +1,128 @@
+package jshapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+/*
+BodyLimits protects resources from oversized or deeply nested request bodies,
+which are rejected with a 413 before being decoded. Zero values inherit the limit
+of the API the resource was added to, and then DefaultBodyLimits.
+*/
+type BodyLimits struct {
+	// MaxBytes is the largest request body accepted
+	MaxBytes int64
+	// MaxDepth is the deepest nesting of JSON objects and arrays accepted
+	MaxDepth int
+}
+
+// DefaultBodyLimits are used when neither a resource nor its API set a limit
+var DefaultBodyLimits = BodyLimits{
+	MaxBytes: 1 << 20,
+	MaxDepth: 32,
+}
+
+// PayloadTooLarge creates a 413 error for request bodies exceeding a limit
+func PayloadTooLarge(detail string) *jsh.Error {
+	return &jsh.Error{
+		Title:  "Payload Too Large",
+		Detail: detail,
+		Status: http.StatusRequestEntityTooLarge,
+	}
+}
+
+// bodyLimits resolves the limits for the resource
+func (res *Resource) bodyLimits() BodyLimits {
+	limits := res.Limits
+
+	inherited := []BodyLimits{DefaultBodyLimits}
+	if res.apiLimits != nil {
+		inherited = []BodyLimits{*res.apiLimits, DefaultBodyLimits}
+	}
+
+	for _, fallback := range inherited {
+		if limits.MaxBytes == 0 {
+			limits.MaxBytes = fallback.MaxBytes
+		}
+		if limits.MaxDepth == 0 {
+			limits.MaxDepth = fallback.MaxDepth
+		}
+	}
+
+	return limits
+}
+
+/*
+limitBody enforces the resource's BodyLimits, reading the body up front so that it
+is never decoded if too large or too deep. The body is restored for parsing.
+*/
+func (res *Resource) limitBody(r *http.Request) *jsh.Error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	limits := res.bodyLimits()
+
+	if limits.MaxBytes > 0 && r.ContentLength > limits.MaxBytes {
+		return PayloadTooLarge(fmt.Sprintf("Request body can not exceed %d bytes", limits.MaxBytes))
+	}
+
+	reader := r.Body
+	if limits.MaxBytes > 0 {
+		reader = ioutil.NopCloser(io.LimitReader(r.Body, limits.MaxBytes+1))
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return jsh.ISE(fmt.Sprintf("Error reading request body: %s", err.Error()))
+	}
+
+	if limits.MaxBytes > 0 && int64(len(body)) > limits.MaxBytes {
+		return PayloadTooLarge(fmt.Sprintf("Request body can not exceed %d bytes", limits.MaxBytes))
+	}
+
+	if limits.MaxDepth > 0 && jsonDepthExceeds(body, limits.MaxDepth) {
+		return PayloadTooLarge(fmt.Sprintf("Request body can not be nested more than %d levels deep", limits.MaxDepth))
+	}
+
+	return nil
+}
+
+// jsonDepthExceeds scans a JSON document without decoding it, checking whether
+// objects and arrays are nested deeper than max. Invalid JSON is left for the
+// parser to report.
+func jsonDepthExceeds(body []byte, max int) bool {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+
+		delim, isDelim := token.(json.Delim)
+		if !isDelim {
+			continue
+		}
+
+		switch delim {
+		case '{', '[':
+			depth++
+			if depth > max {
+				return true
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+}
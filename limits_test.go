@@ -0,0 +1,70 @@
+package jshapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBodyLimits(t *testing.T) {
+
+	resource := NewMockResource(testResourceType, 1, testObjAttrs)
+
+	api := New("")
+	api.Limits = BodyLimits{MaxBytes: 256, MaxDepth: 4}
+	api.Add(resource)
+
+	server := httptest.NewServer(api)
+	baseURL := server.URL
+
+	Convey("Body Limit Tests", t, func() {
+
+		Convey("should accept bodies within the limits", func() {
+			_, resp, err := jsc.Post(baseURL, sampleObject("", testResourceType, testObjAttrs))
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+		})
+
+		Convey("should reject oversized bodies with a 413", func() {
+			attrs := map[string]string{"foo": strings.Repeat("a", 512)}
+			doc, resp, err := jsc.Post(baseURL, sampleObject("", testResourceType, attrs))
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(doc.Errors[0].Title, ShouldEqual, "Payload Too Large")
+		})
+
+		Convey("should reject deeply nested bodies with a 413", func() {
+			attrs := map[string]interface{}{"a": map[string]interface{}{"b": []int{1}}}
+			_, resp, err := jsc.Post(baseURL, sampleObject("", testResourceType, attrs))
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+
+		Convey("should let resources override the API's limits", func() {
+			resource.Limits.MaxDepth = 8
+			defer func() { resource.Limits.MaxDepth = 0 }()
+
+			attrs := map[string]interface{}{"a": map[string]interface{}{"b": []int{1}}}
+			_, resp, err := jsc.Post(baseURL, sampleObject("", testResourceType, attrs))
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+		})
+	})
+}
+
+func TestJSONDepth(t *testing.T) {
+
+	Convey("JSON Depth Tests", t, func() {
+		So(jsonDepthExceeds([]byte(`{"data": {"attributes": {}}}`), 3), ShouldBeFalse)
+		So(jsonDepthExceeds([]byte(`{"data": [[[[]]]]}`), 3), ShouldBeTrue)
+		So(jsonDepthExceeds([]byte(`{"data": `), 3), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,256 @@
+package jshapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+const (
+	requestIDKey = contextKey("requestID")
+	accessKey    = contextKey("access")
+
+	// RequestIDHeader carries the ID of a request, it is generated if the client
+	// doesn't send one and is always echoed back in the response
+	RequestIDHeader = "X-Request-ID"
+)
+
+// Fields are the keys and values of a structured log record
+type Fields map[string]interface{}
+
+/*
+StructuredLogger is the minimal interface needed to write structured log records.
+Adapters for most structured logging libraries are a single method.
+*/
+type StructuredLogger interface {
+	Log(fields Fields)
+}
+
+// JSONLogger writes each record as a single line of JSON
+type JSONLogger struct {
+	// Now stamps each record, defaults to time.Now
+	Now func() time.Time
+
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+// NewJSONLogger creates a JSONLogger writing to out
+func NewJSONLogger(out io.Writer) *JSONLogger {
+	return &JSONLogger{
+		Now: time.Now,
+		out: out,
+	}
+}
+
+// Log implements StructuredLogger, adding a "time" field if one isn't set
+func (l *JSONLogger) Log(fields Fields) {
+	record := Fields{"time": l.Now().UTC().Format(time.RFC3339Nano)}
+	for key, value := range fields {
+		record[key] = value
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		line, _ = json.Marshal(Fields{
+			"time":  record["time"],
+			"level": "error",
+			"msg":   "unable to encode log record",
+			"error": err.Error(),
+		})
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.out.Write(append(line, '\n'))
+}
+
+// RequestIDFromContext returns the ID assigned to a request by an AccessLog
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey).(string)
+	return id, ok
+}
+
+/*
+AccessLog is a middleware that writes a structured record for every request:
+
+	logger := jshapi.NewJSONLogger(os.Stdout)
+	api.Use(jshapi.NewAccessLog(logger).Middleware)
+
+Records include the method, route pattern, resource type, ID, status, duration,
+response size, request ID and principal. Use API.UseStructuredLogging to log ISEs
+in the same format.
+*/
+type AccessLog struct {
+	Logger StructuredLogger
+}
+
+// NewAccessLog creates an AccessLog middleware
+func NewAccessLog(logger StructuredLogger) *AccessLog {
+	return &AccessLog{Logger: logger}
+}
+
+// access collects the details of a request that are only known once it has been
+// routed within a resource
+type access struct {
+	id        string
+	principal string
+}
+
+// Middleware can be registered via API.Use() or Resource.Use()
+func (l *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = randomID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		details := &access{}
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		ctx = context.WithValue(ctx, accessKey, details)
+
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		record := Fields{
+			"level":       "info",
+			"msg":         "request",
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      recorder.Status(),
+			"duration_ms": float64(time.Since(start).Nanoseconds()) / float64(time.Millisecond),
+			"bytes":       recorder.bytes,
+			"request_id":  requestID,
+		}
+
+		if route, routed := RouteFromContext(ctx); routed {
+			record["route"] = route.Pattern
+			record["resource"] = route.ResourceType
+			record["operation"] = string(route.Operation)
+		}
+		if details.id != "" {
+			record["id"] = details.id
+		}
+		if details.principal != "" {
+			record["principal"] = details.principal
+		}
+
+		l.Logger.Log(record)
+	})
+}
+
+// recordAccess notes the object ID and principal of a request being logged by an
+// AccessLog, which are only available once the request has been routed
+func recordAccess(r *http.Request) {
+	details, ok := r.Context().Value(accessKey).(*access)
+	if !ok {
+		return
+	}
+
+	details.id = r.PathValue("id")
+	if principal, authenticated := PrincipalFromContext(r.Context()); authenticated {
+		details.principal = principal.ID
+	}
+}
+
+/*
+StructuredSender is a Sender that logs 5XX errors as structured records, including
+the internal error that isn't shown to the client.
+*/
+func StructuredSender(logger StructuredLogger) Sender {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, sendable jsh.Sendable) {
+		sendableError, isType := sendable.(jsh.ErrorType)
+		if isType && sendableError.StatusCode() >= 500 {
+			logger.Log(errorRecord(ctx, r, "returning ISE", sendableError))
+		}
+
+		sendError := sendPayload(w, r, sendable)
+		if sendError != nil && sendError.Status >= 500 {
+			logger.Log(errorRecord(ctx, r, "error sending response", sendError))
+		}
+	}
+}
+
+// errorRecord describes an internal error
+func errorRecord(ctx context.Context, r *http.Request, msg string, err jsh.ErrorType) Fields {
+	record := Fields{
+		"level":  "error",
+		"msg":    msg,
+		"method": r.Method,
+		"path":   r.URL.Path,
+		"status": err.StatusCode(),
+		"error":  err.Error(),
+	}
+
+	if internal := internalErrors(err); len(internal) > 0 {
+		record["internal"] = internal
+	}
+	if requestID, ok := RequestIDFromContext(ctx); ok {
+		record["request_id"] = requestID
+	}
+	if route, routed := RouteFromContext(ctx); routed {
+		record["route"] = route.Pattern
+		record["resource"] = route.ResourceType
+	}
+	if id := r.PathValue("id"); id != "" {
+		record["id"] = id
+	}
+	if principal, authenticated := PrincipalFromContext(ctx); authenticated {
+		record["principal"] = principal.ID
+	}
+
+	return record
+}
+
+// internalErrors returns the internal messages of an error
+func internalErrors(err jsh.ErrorType) []string {
+	internal := []string{}
+
+	switch typed := err.(type) {
+	case *jsh.Error:
+		if typed.ISE != "" {
+			internal = append(internal, typed.ISE)
+		}
+	case jsh.ErrorList:
+		for _, listed := range typed {
+			internal = append(internal, internalErrors(listed)...)
+		}
+	}
+
+	return internal
+}
+
+// randomID generates a random hex ID, for requests and audit entries
+func randomID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(id)
+}
+
+/*
+UseStructuredLogging logs every request via an AccessLog, and ISEs via a
+StructuredSender, in place of the color terminal logging set up by Default():
+
+	api := jshapi.New("<prefix>")
+	api.UseStructuredLogging(jshapi.NewJSONLogger(os.Stdout))
+
+As with Default(), this replaces the package level SendHandler.
+*/
+func (a *API) UseStructuredLogging(logger StructuredLogger) {
+	SendHandler = StructuredSender(logger)
+	a.Use(NewAccessLog(logger).Middleware)
+}
@@ -0,0 +1,94 @@
+package jshapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStructuredLogging(t *testing.T) {
+
+	Convey("Structured Logging Tests", t, func() {
+
+		defaultSender := SendHandler
+		defer func() { SendHandler = defaultSender }()
+
+		out := &bytes.Buffer{}
+
+		resource := NewMockResource(testResourceType, 1, testObjAttrs)
+		resource.Action("fail", func(ctx context.Context, id string) (*jsh.Object, error) {
+			return nil, jsh.ISE("database unavailable")
+		})
+
+		api := New("")
+		api.UseStructuredLogging(NewJSONLogger(out))
+		api.Use(testPrincipal)
+		api.Add(resource)
+
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		records := func() []map[string]interface{} {
+			parsed := []map[string]interface{}{}
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				record := map[string]interface{}{}
+				So(json.Unmarshal([]byte(line), &record), ShouldBeNil)
+				parsed = append(parsed, record)
+			}
+			return parsed
+		}
+
+		Convey("should log one record per request", func() {
+			request, err := jsc.FetchRequest(server.URL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			request.Header.Set("X-User", "bob")
+			request.Header.Set(RequestIDHeader, "abc")
+
+			_, resp, err := jsc.Do(request, jsh.ObjectMode)
+			So(err, ShouldBeNil)
+			So(resp.Header.Get(RequestIDHeader), ShouldEqual, "abc")
+
+			logged := records()
+			So(logged, ShouldHaveLength, 1)
+
+			record := logged[0]
+			So(record["msg"], ShouldEqual, "request")
+			So(record["method"], ShouldEqual, "GET")
+			So(record["route"], ShouldEqual, "GET /bars/{id}")
+			So(record["resource"], ShouldEqual, testResourceType)
+			So(record["id"], ShouldEqual, "1")
+			So(record["status"], ShouldEqual, http.StatusOK)
+			So(record["bytes"], ShouldBeGreaterThan, 0)
+			So(record["request_id"], ShouldEqual, "abc")
+			So(record["principal"], ShouldEqual, "bob")
+			So(record, ShouldContainKey, "duration_ms")
+			So(record, ShouldContainKey, "time")
+		})
+
+		Convey("should log ISEs with the internal error", func() {
+			resp, err := http.Get(server.URL + "/bars/1/fail")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+			So(resp.Header.Get(RequestIDHeader), ShouldNotBeEmpty)
+
+			logged := records()
+			So(logged, ShouldHaveLength, 2)
+
+			ise := logged[0]
+			So(ise["level"], ShouldEqual, "error")
+			So(ise["internal"], ShouldResemble, []interface{}{"database unavailable"})
+			So(ise["request_id"], ShouldEqual, resp.Header.Get(RequestIDHeader))
+			So(ise["id"], ShouldEqual, "1")
+
+			So(logged[1]["status"], ShouldEqual, http.StatusInternalServerError)
+		})
+	})
+}
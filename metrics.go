@@ -0,0 +1,268 @@
+package jshapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsKey = contextKey("metrics")
+
+// DefaultMetricBuckets are the latency histogram buckets in seconds, matching the
+// defaults used by Prometheus client libraries
+var DefaultMetricBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+/*
+Metrics records request counts, latencies, and in-flight requests for every
+resource operation, along with how long storage calls take. It serves them in the
+Prometheus text exposition format, so no client library is needed:
+
+	metrics := jshapi.NewMetrics()
+	api.Use(metrics.Middleware)
+	api.Handle("GET /metrics", metrics)
+
+Metrics are labelled by resource type and operation (get, list, post, patch,
+delete, relationship, or action), requests by status class (2xx, 4xx, ...) and
+storage calls by outcome (ok or error).
+*/
+type Metrics struct {
+	// Buckets are the upper bounds of latency histograms in seconds
+	Buckets []float64
+
+	mutex    sync.Mutex
+	requests map[metricLabels]int64
+	inFlight map[metricLabels]int64
+	latency  map[metricLabels]*histogram
+	storage  map[metricLabels]*histogram
+}
+
+// metricLabels identifies a single time series
+type metricLabels struct {
+	resource  string
+	operation string
+	// status is the status class of a request, or outcome of a storage call
+	status string
+}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewMetrics creates an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Buckets:  DefaultMetricBuckets,
+		requests: map[metricLabels]int64{},
+		inFlight: map[metricLabels]int64{},
+		latency:  map[metricLabels]*histogram{},
+		storage:  map[metricLabels]*histogram{},
+	}
+}
+
+// Middleware can be registered via API.Use() or Resource.Use()
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		labels := requestLabels(r.Context())
+
+		m.mutex.Lock()
+		m.inFlight[labels]++
+		m.mutex.Unlock()
+
+		// requests that panic are no longer in flight either
+		defer func(labels metricLabels) {
+			m.mutex.Lock()
+			m.inFlight[labels]--
+			m.mutex.Unlock()
+		}(labels)
+
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), metricsKey, m)))
+		elapsed := time.Since(start).Seconds()
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		m.observe(m.latency, labels, elapsed)
+
+		labels.status = fmt.Sprintf("%dxx", recorder.Status()/100)
+		m.requests[labels]++
+	})
+}
+
+// ObserveStorage records how long a storage call took
+func (m *Metrics) ObserveStorage(resourceType string, operation Operation, elapsed time.Duration, err error) {
+	outcome := "ok"
+	if normalizeError(err) != nil {
+		outcome = "error"
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.observe(m.storage, metricLabels{
+		resource:  resourceType,
+		operation: operation.Kind(),
+		status:    outcome,
+	}, elapsed.Seconds())
+}
+
+// observe adds an observation to the histogram for labels
+func (m *Metrics) observe(histograms map[metricLabels]*histogram, labels metricLabels, value float64) {
+	existing, exists := histograms[labels]
+	if !exists {
+		existing = &histogram{counts: make([]uint64, len(m.Buckets))}
+		histograms[labels] = existing
+	}
+
+	for i, bound := range m.Buckets {
+		if value <= bound {
+			existing.counts[i]++
+		}
+	}
+	existing.count++
+	existing.sum += value
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	out := &bytes.Buffer{}
+
+	writeHeader(out, "jshapi_requests_total", "counter", "Requests handled by resource, operation, and status class.")
+	for _, labels := range seriesLabels(m.requests) {
+		fmt.Fprintf(out, "jshapi_requests_total%s %d\n", labels.format("status"), m.requests[labels])
+	}
+
+	writeHeader(out, "jshapi_requests_in_flight", "gauge", "Requests currently being handled.")
+	for _, labels := range seriesLabels(m.inFlight) {
+		fmt.Fprintf(out, "jshapi_requests_in_flight%s %d\n", labels.format(""), m.inFlight[labels])
+	}
+
+	writeHeader(out, "jshapi_request_duration_seconds", "histogram", "Time taken to handle requests.")
+	for _, labels := range histogramLabels(m.latency) {
+		m.writeHistogram(out, "jshapi_request_duration_seconds", labels, "", m.latency[labels])
+	}
+
+	writeHeader(out, "jshapi_storage_duration_seconds", "histogram", "Time taken by storage calls, by outcome.")
+	for _, labels := range histogramLabels(m.storage) {
+		m.writeHistogram(out, "jshapi_storage_duration_seconds", labels, "outcome", m.storage[labels])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(out.Bytes())
+}
+
+// writeHistogram writes the bucket, sum, and count series of a histogram
+func (m *Metrics) writeHistogram(out *bytes.Buffer, name string, labels metricLabels, statusLabel string, h *histogram) {
+	base := labels.format(statusLabel)
+	base = strings.TrimSuffix(base, "}")
+
+	for i, bound := range m.Buckets {
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		fmt.Fprintf(out, "%s_bucket%s,le=\"%s\"} %d\n", name, base, le, h.counts[i])
+	}
+	fmt.Fprintf(out, "%s_bucket%s,le=\"+Inf\"} %d\n", name, base, h.count)
+	fmt.Fprintf(out, "%s_sum%s} %s\n", name, base, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(out, "%s_count%s} %d\n", name, base, h.count)
+}
+
+// format renders labels in the exposition format, including status under the name
+// statusLabel unless it is empty
+func (l metricLabels) format(statusLabel string) string {
+	pairs := []string{
+		fmt.Sprintf("resource=%s", quoteLabel(l.resource)),
+		fmt.Sprintf("operation=%s", quoteLabel(l.operation)),
+	}
+
+	if statusLabel != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", statusLabel, quoteLabel(l.status)))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// quoteLabel quotes and escapes a label value
+func quoteLabel(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return `"` + value + `"`
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func writeHeader(out *bytes.Buffer, name string, metricType string, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// seriesLabels returns the labels of counters and gauges in a stable order
+func seriesLabels(series map[metricLabels]int64) []metricLabels {
+	labels := []metricLabels{}
+	for key := range series {
+		labels = append(labels, key)
+	}
+
+	return sortLabels(labels)
+}
+
+// histogramLabels returns the labels of histograms in a stable order
+func histogramLabels(histograms map[metricLabels]*histogram) []metricLabels {
+	labels := []metricLabels{}
+	for key := range histograms {
+		labels = append(labels, key)
+	}
+
+	return sortLabels(labels)
+}
+
+// sortLabels sorts labels by their formatted value
+func sortLabels(labels []metricLabels) []metricLabels {
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].format("status") < labels[j].format("status")
+	})
+
+	return labels
+}
+
+// requestLabels labels a request using its resolved Route
+func requestLabels(ctx context.Context) metricLabels {
+	route, routed := RouteFromContext(ctx)
+	if !routed {
+		return metricLabels{operation: "unmatched"}
+	}
+
+	return metricLabels{
+		resource:  route.ResourceType,
+		operation: route.Operation.Kind(),
+	}
+}
+
+// observeStorage starts timing a storage call for the Metrics handling a request,
+// returning a function to call with the storage error once it returns
+func (res *Resource) observeStorage(ctx context.Context) func(error) {
+	metrics, ok := ctx.Value(metricsKey).(*Metrics)
+	if !ok {
+		return func(error) {}
+	}
+
+	operation := Operation("unmatched")
+	if route, routed := RouteFromContext(ctx); routed {
+		operation = route.Operation
+	}
+
+	start := time.Now()
+	return func(err error) {
+		metrics.ObserveStorage(res.Type, operation, time.Since(start), err)
+	}
+}
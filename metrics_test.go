@@ -0,0 +1,82 @@
+package jshapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+
+	Convey("Metrics Tests", t, func() {
+
+		metrics := NewMetrics()
+
+		api := New("api")
+		api.Use(metrics.Middleware)
+		api.Handle("GET /metrics", metrics)
+		api.Add(NewMockResource(testResourceType, 1, testObjAttrs))
+
+		server := httptest.NewServer(api)
+		defer server.Close()
+		baseURL := server.URL + api.prefix
+
+		_, resp, err := jsc.Fetch(baseURL, testResourceType, "1")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+		_, resp, err = jsc.List(baseURL, testResourceType)
+		So(err, ShouldBeNil)
+
+		resp, err = http.Get(server.URL + "/metrics")
+		So(err, ShouldBeNil)
+		So(resp.Header.Get("Content-Type"), ShouldStartWith, "text/plain; version=0.0.4")
+
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		exposition := string(body)
+
+		Convey("should count requests by status class", func() {
+			So(exposition, ShouldContainSubstring, "# TYPE jshapi_requests_total counter")
+			So(exposition, ShouldContainSubstring, `jshapi_requests_total{resource="bars",operation="get",status="2xx"} 1`)
+			So(exposition, ShouldContainSubstring, `jshapi_requests_total{resource="bars",operation="list",status="2xx"} 1`)
+		})
+
+		Convey("should record latency histograms", func() {
+			So(exposition, ShouldContainSubstring, `jshapi_request_duration_seconds_bucket{resource="bars",operation="get",le="+Inf"} 1`)
+			So(exposition, ShouldContainSubstring, `jshapi_request_duration_seconds_count{resource="bars",operation="get"} 1`)
+		})
+
+		Convey("should record storage durations", func() {
+			So(exposition, ShouldContainSubstring, `jshapi_storage_duration_seconds_count{resource="bars",operation="get",outcome="ok"} 1`)
+		})
+
+		Convey("should track requests in flight", func() {
+			So(exposition, ShouldContainSubstring, `jshapi_requests_in_flight{resource="bars",operation="get"} 0`)
+		})
+
+		Convey("should stop tracking requests that panic", func() {
+			handler := metrics.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("storage failure")
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			So(func() { handler.ServeHTTP(httptest.NewRecorder(), request) }, ShouldPanic)
+			So(metrics.inFlight[requestLabels(request.Context())], ShouldEqual, 0)
+		})
+	})
+}
+
+func TestOperationKind(t *testing.T) {
+
+	Convey("Operation Kind Tests", t, func() {
+		So(OpGet.Kind(), ShouldEqual, "get")
+		So(ToOneOperation("user").Kind(), ShouldEqual, "relationship")
+		So(ToManyOperation("user").Kind(), ShouldEqual, "relationship")
+		So(ActionOperation("reset").Kind(), ShouldEqual, "action")
+	})
+}
@@ -0,0 +1,40 @@
+package jshapi
+
+import (
+	"net/http"
+
+	"goji.io"
+
+	xcontext "golang.org/x/net/context"
+)
+
+/*
+GojiMiddleware adapts context-aware goji middleware, such as goji2-logger, into a
+standard net/http middleware. The context passed to the goji middleware is the
+request's context, and any context it passes on replaces the request's context:
+
+	api.Use(jshapi.GojiMiddleware(gojilogger.Middleware))
+*/
+func GojiMiddleware(middleware func(goji.Handler) goji.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		inner := goji.HandlerFunc(func(ctx xcontext.Context, w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+
+		outer := middleware(inner)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			outer.ServeHTTPC(r.Context(), w, r)
+		})
+	}
+}
+
+// chain wraps a handler with middleware so that the first middleware is called
+// first
+func chain(middleware []func(http.Handler) http.Handler, handler http.Handler) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
@@ -1,60 +1,226 @@
 package jshapi
 
 import (
+	"context"
 	"log"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/derekdowling/go-json-spec-handler"
-	"golang.org/x/net/context"
+	"github.com/derekdowling/jsh-api/store"
 )
 
-// MockStorage allows you to mock out APIs really easily, and is also used internally
-// for testing the API layer.
+// Bulk storage operations recorded by MockStorage, alongside the store.Call*
+// operations
+const (
+	MockSaveAll   = "saveAll"
+	MockUpdateAll = "updateAll"
+	MockDeleteAll = "deleteAll"
+)
+
+/*
+MockStorage allows you to mock out APIs really easily, and is also used internally
+for testing the API layer.
+
+By default it is stateless, returning sample objects built from ResourceAttributes.
+Set Stateful to keep saved objects instead, so that what is saved can be fetched,
+updated, and deleted again. Individual calls can be scripted to fail, take time,
+or return specific objects, and every call is recorded:
+
+	mock := jshapi.NewMockStorage("users", 2, attributes)
+	mock.Stateful = true
+	mock.On(store.CallGet,
+		&jshapi.MockResponse{Err: jsh.NotFound("users", "1")},
+		&jshapi.MockResponse{Delay: time.Second},
+	)
+
+	api.Add(jshapi.NewCRUDResource("users", mock))
+	...
+	calls := mock.Calls(store.CallGet)
+*/
 type MockStorage struct {
 	// ResourceType is the name of the resource you are mocking i.e. "user", "comment"
 	ResourceType string
 	// ResourceAttributes a sample set of attributes a resource object should have
 	// used by GET /resources and GET /resources/:id
 	ResourceAttributes interface{}
-	// ListCount is the number of sample objects to return in a GET /resources request
+	// ListCount is the number of sample objects to return in a GET /resources request,
+	// or the number of sample objects a Stateful mock starts out with
 	ListCount int
+	// Stateful keeps saved objects in memory rather than returning samples
+	Stateful bool
+
+	mutex sync.Mutex
+	// memory holds the objects of a Stateful mock, created on first use
+	memory *store.Memory
+	// scripts are the responses still to be played for each operation
+	scripts map[string][]*MockResponse
+	calls   []*MockCall
+}
+
+/*
+MockResponse scripts the outcome of a single storage call. The call waits for Delay
+first, then returns Err if set, or Object or List if set. A response that only
+sets Delay is followed by the mock's usual behavior.
+*/
+type MockResponse struct {
+	Err    error
+	Delay  time.Duration
+	Object *jsh.Object
+	List   jsh.List
 }
 
-// Save assigns a URL of 1 to the object
-func (m *MockStorage) Save(ctx context.Context, object *jsh.Object) (*jsh.Object, jsh.ErrorType) {
-	var err *jsh.Error
+// MockCall records a call made to MockStorage
+type MockCall struct {
+	// Operation is one of the store.Call* or Mock* operations
+	Operation string
+	// ID is set for calls made with an ID, or with an object
+	ID string
+	// IDs is set by DeleteAll
+	IDs []string
+	// Object is a copy of the object being saved or updated
+	Object *jsh.Object
+	// List is a copy of the objects saved or updated in bulk
+	List jsh.List
+	// Context is the context storage was called with, allowing tests to check the
+	// principal, tenant, or query the call was made with
+	Context context.Context
+}
+
+// NewMockStorage creates stateless MockStorage for a resource type
+func NewMockStorage(resourceType string, listCount int, sampleObject interface{}) *MockStorage {
+	return &MockStorage{
+		ResourceType:       resourceType,
+		ResourceAttributes: sampleObject,
+		ListCount:          listCount,
+	}
+}
+
+/*
+On scripts the responses to the next calls of an operation, one response per call
+in the order given. Once they have been used up the operation behaves as usual
+again. Calling On again appends to the responses still to be played.
+*/
+func (m *MockStorage) On(operation string, responses ...*MockResponse) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.scripts == nil {
+		m.scripts = map[string][]*MockResponse{}
+	}
+
+	m.scripts[operation] = append(m.scripts[operation], responses...)
+}
+
+// Calls returns the calls made to the mock in order, only those of the given
+// operations if any are specified
+func (m *MockStorage) Calls(operations ...string) []*MockCall {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	calls := []*MockCall{}
+	for _, call := range m.calls {
+		if len(operations) == 0 || containsString(operations, call.Operation) {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
+
+// Reset forgets recorded calls, scripted responses, and the objects of a Stateful
+// mock
+func (m *MockStorage) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.calls = nil
+	m.scripts = nil
+	m.memory = nil
+}
+
+// Save assigns an ID of 1 to the object, or the next ID if Stateful
+func (m *MockStorage) Save(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+	scripted, err := m.call(ctx, &MockCall{Operation: store.CallSave, ID: object.ID, Object: copyMockObject(object)})
+	if scripted.answers() || err != nil {
+		return scripted.object(), scripted.fail(err)
+	}
+
+	if m.Stateful {
+		return m.state().Save(ctx, object)
+	}
+
+	var saveErr *jsh.Error
 	object.ID = "1"
 
-	return object, err
+	return object, saveErr
 }
 
-// Get returns a resource with ID as specified by the request
-func (m *MockStorage) Get(ctx context.Context, id string) (*jsh.Object, jsh.ErrorType) {
-	var err *jsh.Error
+// Get returns a resource with ID as specified by the request, or the stored
+// object if Stateful
+func (m *MockStorage) Get(ctx context.Context, id string) (*jsh.Object, error) {
+	scripted, err := m.call(ctx, &MockCall{Operation: store.CallGet, ID: id})
+	if scripted.answers() || err != nil {
+		return scripted.object(), scripted.fail(err)
+	}
+
+	if m.Stateful {
+		return m.state().Get(ctx, id)
+	}
+
+	var getErr *jsh.Error
 
-	return m.SampleObject(id), err
+	return m.SampleObject(id), getErr
 }
 
-// List returns a sample list
-func (m *MockStorage) List(ctx context.Context) (jsh.List, jsh.ErrorType) {
-	var err *jsh.Error
+// List returns a sample list, or every stored object if Stateful
+func (m *MockStorage) List(ctx context.Context) (jsh.List, error) {
+	scripted, err := m.call(ctx, &MockCall{Operation: store.CallList})
+	if scripted.answers() || err != nil {
+		return scripted.list(), scripted.fail(err)
+	}
+
+	if m.Stateful {
+		return m.state().List(ctx)
+	}
 
-	return m.SampleList(m.ListCount), err
+	var listErr *jsh.Error
+
+	return m.SampleList(m.ListCount), listErr
 }
 
-// Update does nothing
-func (m *MockStorage) Update(ctx context.Context, object *jsh.Object) (*jsh.Object, jsh.ErrorType) {
-	var err jsh.ErrorList
-	err = nil
+// Update does nothing, unless Stateful
+func (m *MockStorage) Update(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+	scripted, err := m.call(ctx, &MockCall{Operation: store.CallUpdate, ID: object.ID, Object: copyMockObject(object)})
+	if scripted.answers() || err != nil {
+		return scripted.object(), scripted.fail(err)
+	}
+
+	if m.Stateful {
+		return m.state().Update(ctx, object)
+	}
 
-	return object, err
+	var updateErr jsh.ErrorList
+	updateErr = nil
+
+	return object, updateErr
 }
 
-// Delete does nothing
-func (m *MockStorage) Delete(ctx context.Context, id string) jsh.ErrorType {
-	var err *jsh.Error
+// Delete does nothing, unless Stateful
+func (m *MockStorage) Delete(ctx context.Context, id string) error {
+	scripted, err := m.call(ctx, &MockCall{Operation: store.CallDelete, ID: id})
+	if scripted.answers() || err != nil {
+		return scripted.fail(err)
+	}
 
-	return err
+	if m.Stateful {
+		return m.state().Delete(ctx, id)
+	}
+
+	var deleteErr *jsh.Error
+
+	return deleteErr
 }
 
 // SampleObject builds an object based on provided resource specifications
@@ -79,3 +245,194 @@ func (m *MockStorage) SampleList(length int) jsh.List {
 
 	return list
 }
+
+// SaveAll assigns sequential IDs to each object in the list, or stores each
+// object if Stateful
+func (m *MockStorage) SaveAll(ctx context.Context, list jsh.List) (jsh.List, error) {
+	scripted, err := m.call(ctx, &MockCall{Operation: MockSaveAll, List: copyMockList(list)})
+	if scripted.answers() || err != nil {
+		return scripted.list(), scripted.fail(err)
+	}
+
+	if m.Stateful {
+		return m.eachObject(ctx, list, m.state().Save)
+	}
+
+	var saveErr *jsh.Error
+
+	for index, object := range list {
+		object.ID = strconv.Itoa(index + 1)
+	}
+
+	return list, saveErr
+}
+
+// UpdateAll does nothing, unless Stateful
+func (m *MockStorage) UpdateAll(ctx context.Context, list jsh.List) (jsh.List, error) {
+	scripted, err := m.call(ctx, &MockCall{Operation: MockUpdateAll, List: copyMockList(list)})
+	if scripted.answers() || err != nil {
+		return scripted.list(), scripted.fail(err)
+	}
+
+	if m.Stateful {
+		return m.eachObject(ctx, list, m.state().Update)
+	}
+
+	var updateErr *jsh.Error
+
+	return list, updateErr
+}
+
+// DeleteAll does nothing, unless Stateful
+func (m *MockStorage) DeleteAll(ctx context.Context, ids []string) error {
+	scripted, err := m.call(ctx, &MockCall{Operation: MockDeleteAll, IDs: append([]string{}, ids...)})
+	if scripted.answers() || err != nil {
+		return scripted.fail(err)
+	}
+
+	if m.Stateful {
+		for _, id := range ids {
+			deleteErr := m.state().Delete(ctx, id)
+			if normalizeError(deleteErr) != nil {
+				return deleteErr
+			}
+		}
+	}
+
+	var deleteErr *jsh.Error
+
+	return deleteErr
+}
+
+/*
+call records a call and plays the next response scripted for its operation,
+returning nil if there isn't one. An error is returned if the request is cancelled
+while the response is delayed.
+*/
+func (m *MockStorage) call(ctx context.Context, call *MockCall) (*MockResponse, error) {
+	call.Context = ctx
+
+	m.mutex.Lock()
+	m.calls = append(m.calls, call)
+
+	var scripted *MockResponse
+	if responses := m.scripts[call.Operation]; len(responses) > 0 {
+		scripted = responses[0]
+		m.scripts[call.Operation] = responses[1:]
+	}
+	m.mutex.Unlock()
+
+	if scripted == nil || scripted.Delay <= 0 {
+		return scripted, nil
+	}
+
+	delay := time.NewTimer(scripted.Delay)
+	defer delay.Stop()
+
+	select {
+	case <-delay.C:
+		return scripted, nil
+	case <-ctx.Done():
+		return scripted, ctx.Err()
+	}
+}
+
+// state returns the objects of a Stateful mock, starting out with ListCount
+// sample objects
+func (m *MockStorage) state() *store.Memory {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.memory == nil {
+		m.memory = store.NewMemory(m.ResourceType)
+		for _, object := range m.SampleList(m.ListCount) {
+			m.memory.Save(context.Background(), object)
+		}
+	}
+
+	return m.memory
+}
+
+// eachObject saves or updates each object of a bulk call in turn
+func (m *MockStorage) eachObject(
+	ctx context.Context,
+	list jsh.List,
+	storage func(context.Context, *jsh.Object) (*jsh.Object, error),
+) (jsh.List, error) {
+	stored := jsh.List{}
+
+	for _, object := range list {
+		result, err := storage(ctx, object)
+		if normalizeError(err) != nil {
+			return nil, err
+		}
+
+		stored = append(stored, result)
+	}
+
+	return stored, nil
+}
+
+// answers checks whether a scripted response replaces the mock's usual behavior
+func (r *MockResponse) answers() bool {
+	return r != nil && (r.Err != nil || r.Object != nil || r.List != nil)
+}
+
+// object returns the scripted object, if any
+func (r *MockResponse) object() *jsh.Object {
+	if r == nil {
+		return nil
+	}
+
+	return r.Object
+}
+
+// list returns the scripted list, if any
+func (r *MockResponse) list() jsh.List {
+	if r == nil {
+		return nil
+	}
+
+	return r.List
+}
+
+// fail returns the error from a cancelled delay, or the scripted error
+func (r *MockResponse) fail(err error) error {
+	if err != nil || r == nil {
+		return err
+	}
+
+	return r.Err
+}
+
+// copyMockObject copies a recorded object so that later changes to it aren't
+// recorded
+func copyMockObject(object *jsh.Object) *jsh.Object {
+	if object == nil {
+		return nil
+	}
+
+	copied := *object
+	return &copied
+}
+
+// copyMockList copies each object of a recorded list
+func copyMockList(list jsh.List) jsh.List {
+	copied := jsh.List{}
+	for _, object := range list {
+		copied = append(copied, copyMockObject(object))
+	}
+
+	return copied
+}
+
+// containsString checks whether values contains value
+func containsString(values []string, value string) bool {
+	for _, existing := range values {
+		if existing == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,98 @@
+package jshapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	"github.com/derekdowling/jsh-api/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMockStorage(t *testing.T) {
+
+	Convey("MockStorage Tests", t, func() {
+
+		mock := NewMockStorage(testResourceType, 2, testObjAttrs)
+
+		api := New("")
+		api.Add(NewCRUDResource(testResourceType, mock))
+
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		Convey("should play scripted responses in order", func() {
+			mock.On(store.CallGet,
+				&MockResponse{Err: jsh.NotFound(testResourceType, "1")},
+				&MockResponse{Err: &jsh.Error{Title: "Unavailable", Status: http.StatusServiceUnavailable}},
+			)
+
+			_, resp, err := jsc.Fetch(server.URL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			_, resp, err = jsc.Fetch(server.URL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+
+			// back to sample objects once the script has been played
+			doc, resp, err := jsc.Fetch(server.URL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(doc.Data[0].ID, ShouldEqual, "1")
+		})
+
+		Convey("should delay scripted responses until the request is cancelled", func() {
+			mock.On(store.CallList, &MockResponse{Delay: time.Second})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			_, err := mock.List(ctx)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+
+		Convey("should keep state when Stateful", func() {
+			mock.Stateful = true
+
+			doc, resp, err := jsc.Post(server.URL, sampleObject("", testResourceType, testObjAttrs))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+			So(doc.Data[0].ID, ShouldEqual, "3")
+
+			_, resp, err = jsc.Patch(server.URL, sampleObject("3", testResourceType, map[string]string{"foo": "baz"}))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			doc, resp, err = jsc.Fetch(server.URL, testResourceType, "3")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(string(doc.Data[0].Attributes), ShouldContainSubstring, "baz")
+
+			doc, _, err = jsc.List(server.URL, testResourceType)
+			So(err, ShouldBeNil)
+			So(doc.Data, ShouldHaveLength, 3)
+
+			Convey("should record calls", func() {
+				So(mock.Calls(), ShouldHaveLength, 4)
+
+				updates := mock.Calls(store.CallUpdate)
+				So(updates, ShouldHaveLength, 1)
+				So(updates[0].ID, ShouldEqual, "3")
+				So(string(updates[0].Object.Attributes), ShouldContainSubstring, "baz")
+
+				route, routed := RouteFromContext(updates[0].Context)
+				So(routed, ShouldBeTrue)
+				So(route.Operation, ShouldEqual, OpPatch)
+
+				mock.Reset()
+				So(mock.Calls(), ShouldBeEmpty)
+			})
+		})
+	})
+}
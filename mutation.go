@@ -0,0 +1,223 @@
+package jshapi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/jsh-api/store"
+)
+
+/*
+mutation tracks a change to a resource's objects from before storage is called
+until it succeeds, so it can be recorded in the resource's audit log and published
+to its change feeds.
+*/
+type mutation struct {
+	resource  *Resource
+	operation Operation
+	principal string
+	requestID string
+	ids       []string
+	before    map[string]*jsh.Object
+}
+
+/*
+observeMutation starts tracking a mutation of the objects identified by ids,
+snapshotting them before they change if they will be needed. It returns nil if
+nothing observes the resource's mutations, which is safe to record.
+*/
+func (res *Resource) observeMutation(ctx context.Context, operation Operation, ids ...string) *mutation {
+	if res.auditSink == nil && len(res.listeners) == 0 {
+		return nil
+	}
+
+	observed := &mutation{
+		resource:  res,
+		operation: operation,
+		ids:       ids,
+		before:    map[string]*jsh.Object{},
+	}
+
+	if principal, authenticated := PrincipalFromContext(ctx); authenticated {
+		observed.principal = principal.ID
+	}
+	if requestID, ok := RequestIDFromContext(ctx); ok {
+		observed.requestID = requestID
+	}
+
+	// listeners only need snapshots to keep deletes within their tenant
+	snapshot := res.auditSink != nil || (operation == OpDelete && res.tenantAttribute != "")
+	if !snapshot || res.get == nil {
+		return observed
+	}
+
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+
+		storageCtx, observe := res.instrument(ctx, id)
+		object, err := res.get(storageCtx, id)
+		observe(err)
+
+		// objects that can't be fetched are reported by the mutation itself
+		if normalizeError(err) == nil {
+			observed.before[id] = object
+		}
+	}
+
+	return observed
+}
+
+// record audits and publishes the change to each object after it changed, or to
+// each object identified if the mutation was a delete. Within a transaction, this
+// is held back until it is committed.
+func (m *mutation) record(ctx context.Context, after ...*jsh.Object) jsh.ErrorType {
+	if m == nil {
+		return nil
+	}
+
+	now := time.Now()
+	entries := []*AuditEntry{}
+
+	if len(after) == 0 {
+		for _, id := range m.ids {
+			entries = append(entries, m.newEntry(now, id, nil))
+		}
+	}
+
+	for _, object := range after {
+		if object == nil {
+			continue
+		}
+
+		// actions can respond with an object other than the one they changed
+		id := object.ID
+		if len(m.ids) == 1 && len(after) == 1 {
+			id = m.ids[0]
+		}
+
+		entries = append(entries, m.newEntry(now, id, object))
+	}
+
+	if effects, inTx := ctx.Value(effectsKey).(*sideEffects); inTx && effects.hold(func() jsh.ErrorType {
+		return m.publish(ctx, entries)
+	}) {
+		return nil
+	}
+
+	return m.publish(ctx, entries)
+}
+
+// publish records entries in the resource's audit log and publishes them to its
+// change feeds
+func (m *mutation) publish(ctx context.Context, entries []*AuditEntry) jsh.ErrorType {
+	if m.resource.auditSink != nil {
+		for _, entry := range entries {
+			err := m.resource.auditSink.Record(ctx, entry)
+			if err != nil {
+				return jsh.ISE(fmt.Sprintf("Error recording audit entry: %s", err.Error()))
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		publishErr := m.resource.publish(ctx, entry)
+		if publishErr != nil {
+			return publishErr
+		}
+	}
+
+	return nil
+}
+
+// newEntry describes the change to a single object
+func (m *mutation) newEntry(now time.Time, id string, after *jsh.Object) *AuditEntry {
+	return &AuditEntry{
+		ID:           randomID(),
+		Time:         now,
+		Principal:    m.principal,
+		RequestID:    m.requestID,
+		ResourceType: m.resource.Type,
+		ResourceID:   id,
+		Operation:    m.operation,
+		Before:       m.before[id],
+		After:        after,
+	}
+}
+
+// job records the mutation once an asynchronous job completes it
+func (m *mutation) job(work store.Job) store.Job {
+	if m == nil {
+		return work
+	}
+
+	return func(ctx context.Context) (*jsh.Object, error) {
+		object, err := work(ctx)
+		if normalizeError(err) != nil {
+			return object, err
+		}
+
+		recordErr := m.record(ctx, object)
+		if recordErr != nil {
+			return nil, recordErr
+		}
+
+		return object, nil
+	}
+}
+
+// effectsKey stores the sideEffects of a request's transaction within its context
+const effectsKey = contextKey("effects")
+
+/*
+sideEffects holds back the audit entries and change events of a request's
+mutations while its transaction is in progress, so that they are only published
+for changes that are committed.
+*/
+type sideEffects struct {
+	mutex    sync.Mutex
+	pending  []func() jsh.ErrorType
+	finished bool
+}
+
+// hold holds back a side effect, returning false if the transaction has already
+// finished and the side effect should happen right away
+func (e *sideEffects) hold(effect func() jsh.ErrorType) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.finished {
+		return false
+	}
+
+	e.pending = append(e.pending, effect)
+	return true
+}
+
+// flush performs the held back side effects once the transaction is committed
+func (e *sideEffects) flush() jsh.ErrorType {
+	for _, effect := range e.finish() {
+		err := effect()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// finish ends the transaction, returning the held back side effects
+func (e *sideEffects) finish() []func() jsh.ErrorType {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.finished = true
+	pending := e.pending
+	e.pending = nil
+
+	return pending
+}
@@ -0,0 +1,229 @@
+package jshapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+// Operation identifies an action performed against a resource so that a policy
+// can be attached to it
+type Operation string
+
+const (
+	// OpGet is `GET /resources/:id`
+	OpGet Operation = "get"
+	// OpList is `GET /resources`
+	OpList Operation = "list"
+	// OpPost is `POST /resources`
+	OpPost Operation = "post"
+	// OpPatch is `PATCH /resources/:id`
+	OpPatch Operation = "patch"
+	// OpDelete is `DELETE /resources/:id`
+	OpDelete Operation = "delete"
+	// OpRestore is `POST /resources/:id/restore`, see SoftDelete
+	OpRestore Operation = "action:restore"
+	// opAll is used by policies which apply to every operation
+	opAll Operation = "*"
+)
+
+// ToOneOperation identifies the relationship registered via .ToOne(resourceType)
+func ToOneOperation(resourceType string) Operation {
+	return Operation("toOne:" + strings.TrimSuffix(resourceType, "s"))
+}
+
+// ToManyOperation identifies the relationship registered via .ToMany(resourceType)
+func ToManyOperation(resourceType string) Operation {
+	if !strings.HasSuffix(resourceType, "s") {
+		resourceType = fmt.Sprintf("%ss", resourceType)
+	}
+
+	return Operation("toMany:" + resourceType)
+}
+
+// ActionOperation identifies the action registered via .Action(actionName)
+func ActionOperation(actionName string) Operation {
+	return Operation("action:" + actionName)
+}
+
+// Kind groups operations for reporting, returning "relationship" or "action" for
+// those registered by name and the operation itself otherwise
+func (o Operation) Kind() string {
+	switch {
+	case strings.HasPrefix(string(o), "toOne:"), strings.HasPrefix(string(o), "toMany:"):
+		return "relationship"
+	case strings.HasPrefix(string(o), "action:"):
+		return "action"
+	}
+
+	return string(o)
+}
+
+// Target is what an operation is being performed on. ID is empty for `POST` and
+// `GET /resources`, and Object is only set for requests containing one.
+type Target struct {
+	ID     string
+	Object *jsh.Object
+}
+
+/*
+Authorizer decides whether a caller may perform an operation. The principal is
+nil for anonymous requests. Returning nil allows the operation, a jsh.ErrorType is
+sent as is, and any other error is sent as a 403 using its message as the detail.
+*/
+type Authorizer func(ctx context.Context, principal *Principal, operation Operation, target Target) error
+
+/*
+Scope narrows the results of `GET /resources` to what a caller is allowed to see,
+rather than rejecting the request outright.
+*/
+type Scope func(ctx context.Context, principal *Principal, list jsh.List) (jsh.List, error)
+
+/*
+Authorize attaches an authorizer to one or more operations, or to every operation
+if none are specified. A policy attached to a specific operation takes precedence
+over one attached to every operation:
+
+	posts.Authorize(func(ctx context.Context, p *jshapi.Principal, op jshapi.Operation, target jshapi.Target) error {
+		if p == nil || !isOwner(ctx, p, target.ID) {
+			return errors.New("Only the owner can modify a post")
+		}
+		return nil
+	}, jshapi.OpPatch, jshapi.OpDelete)
+*/
+func (res *Resource) Authorize(authorizer Authorizer, operations ...Operation) {
+	if len(operations) == 0 {
+		operations = []Operation{opAll}
+	}
+
+	for _, operation := range operations {
+		res.policies[operation] = authorizer
+	}
+}
+
+// ScopeList filters every list returned by the resource's List storage
+func (res *Resource) ScopeList(scope Scope) {
+	res.scope = scope
+}
+
+// Forbidden creates a 403 error for requests that failed authorization
+func Forbidden(detail string) *jsh.Error {
+	return &jsh.Error{
+		Title:  "Forbidden",
+		Detail: detail,
+		Status: http.StatusForbidden,
+	}
+}
+
+// authorize checks the policy for an operation, sending an error and returning
+// false if the caller isn't allowed to perform it
+func (res *Resource) authorize(w http.ResponseWriter, r *http.Request, operation Operation, target Target) bool {
+	ctx := r.Context()
+
+	authErr := res.authorization(ctx, operation, target)
+	if authErr != nil {
+		SendHandler(ctx, w, r, authErr)
+		return false
+	}
+
+	return true
+}
+
+// authorizeList checks the policy for an operation against every object in a
+// bulk request
+func (res *Resource) authorizeList(w http.ResponseWriter, r *http.Request, operation Operation, list jsh.List) bool {
+	ctx := r.Context()
+
+	errors := jsh.ErrorList{}
+	for index, object := range list {
+		authErr := res.authorization(ctx, operation, Target{ID: object.ID, Object: object})
+
+		switch typedErr := authErr.(type) {
+		case *jsh.Error:
+			errors = append(errors, BulkError(index, typedErr))
+		case jsh.ErrorList:
+			for _, err := range typedErr {
+				errors = append(errors, BulkError(index, err))
+			}
+		}
+	}
+
+	if len(errors) > 0 {
+		SendHandler(ctx, w, r, errors)
+		return false
+	}
+
+	return true
+}
+
+// authorization checks tenancy and runs the policy for an operation, and the
+// attribute policies for any object being written, returning nil if allowed
+func (res *Resource) authorization(ctx context.Context, operation Operation, target Target) jsh.ErrorType {
+	// objects of another tenant don't exist as far as the caller is concerned
+	tenantErr := res.tenancy(ctx, operation, target)
+	if tenantErr != nil {
+		return tenantErr
+	}
+
+	// as are soft deleted objects
+	deletedErr := res.deletedTarget(ctx, operation, target)
+	if deletedErr != nil {
+		return deletedErr
+	}
+
+	policyErr := res.policy(ctx, operation, target)
+	if policyErr != nil {
+		return policyErr
+	}
+
+	// attributes written by the request are subject to their own policies
+	if target.Object != nil && (operation == OpPost || operation == OpPatch) {
+		writeErr := res.deletedWrite(target.Object)
+		if writeErr != nil {
+			return writeErr
+		}
+
+		return res.attributeWrites(ctx, operation, target.Object)
+	}
+
+	return nil
+}
+
+// policy runs the authorizer registered for an operation, returning nil if allowed
+func (res *Resource) policy(ctx context.Context, operation Operation, target Target) jsh.ErrorType {
+	authorizer, exists := res.policies[operation]
+	if !exists {
+		authorizer, exists = res.policies[opAll]
+	}
+
+	if !exists {
+		return nil
+	}
+
+	principal, _ := PrincipalFromContext(ctx)
+
+	err := normalizeError(authorizer(ctx, principal, operation, target))
+	if errorType, isType := err.(jsh.ErrorType); isType {
+		return errorType
+	}
+
+	if err != nil {
+		return Forbidden(err.Error())
+	}
+
+	return nil
+}
+
+// scopeList applies tenancy and the resource's list scope if one is set
+func (res *Resource) scopeList(ctx context.Context, list jsh.List) (jsh.List, error) {
+	list = res.tenantList(ctx, list)
+	if res.scope == nil {
+		return list, nil
+	}
+
+	principal, _ := PrincipalFromContext(ctx)
+	return res.scope(ctx, principal, list)
+}
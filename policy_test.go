@@ -0,0 +1,116 @@
+package jshapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testPrincipal authenticates requests using an "X-User" header, for tests only
+func testPrincipal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get("X-User")
+		if user != "" {
+			r = r.WithContext(WithPrincipal(r.Context(), &Principal{ID: user}))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestPolicies(t *testing.T) {
+
+	resource := NewMockResource(testResourceType, 3, testObjAttrs)
+	resource.Action("reset", func(ctx context.Context, id string) (*jsh.Object, error) {
+		return sampleObject(id, testResourceType, testObjAttrs), nil
+	})
+
+	resource.Authorize(func(ctx context.Context, principal *Principal, operation Operation, target Target) error {
+		if principal == nil || principal.ID != target.ID {
+			return errors.New("Only the owner can do that")
+		}
+		return nil
+	}, OpPatch, OpDelete, ActionOperation("reset"))
+
+	resource.ScopeList(func(ctx context.Context, principal *Principal, list jsh.List) (jsh.List, error) {
+		if principal != nil {
+			return list, nil
+		}
+		return list[:1], nil
+	})
+
+	api := New("")
+	api.Use(testPrincipal)
+	api.Add(resource)
+
+	server := httptest.NewServer(api)
+	baseURL := server.URL
+
+	Convey("Policy Tests", t, func() {
+
+		Convey("should allow operations without a policy", func() {
+			_, resp, err := jsc.Fetch(baseURL, testResourceType, "1")
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("should narrow lists using the scope", func() {
+			doc, resp, err := jsc.List(baseURL, testResourceType)
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(len(doc.Data), ShouldEqual, 1)
+
+			request, err := jsc.ListRequest(baseURL, testResourceType)
+			So(err, ShouldBeNil)
+			request.Header.Set("X-User", "1")
+
+			doc, resp, err = jsc.Do(request, jsh.ListMode)
+			So(err, ShouldBeNil)
+			So(len(doc.Data), ShouldEqual, 3)
+		})
+
+		Convey("should reject unauthorized operations with a 403", func() {
+			object := sampleObject("1", testResourceType, testObjAttrs)
+			request, err := jsc.PatchRequest(baseURL, object)
+			So(err, ShouldBeNil)
+			request.Header.Set("X-User", "2")
+
+			doc, resp, err := jsc.Do(request, jsh.ObjectMode)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			So(doc.Errors[0].Detail, ShouldEqual, "Only the owner can do that")
+
+			request, err = jsc.PatchRequest(baseURL, object)
+			So(err, ShouldBeNil)
+			request.Header.Set("X-User", "1")
+
+			_, resp, err = jsc.Do(request, jsh.ObjectMode)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("should authorize actions", func() {
+			_, resp, err := jsc.Action(baseURL, testResourceType, "1", "reset")
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestOperations(t *testing.T) {
+
+	Convey("Operation Tests", t, func() {
+		So(ToOneOperation("users"), ShouldEqual, ToOneOperation("user"))
+		So(ToManyOperation("user"), ShouldEqual, ToManyOperation("users"))
+		So(ActionOperation("reset"), ShouldNotEqual, ActionOperation("resets"))
+	})
+}
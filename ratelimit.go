@@ -0,0 +1,284 @@
+package jshapi
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+// RateLimit allows Requests per Period, with bursts of up to Burst requests
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	// Burst is the size of the bucket, defaults to Requests
+	Burst int
+}
+
+// validate rejects limits that would never refill a bucket
+func (l RateLimit) validate() {
+	if l.Requests <= 0 || l.Period <= 0 {
+		panic(fmt.Sprintf(
+			"jshapi: rate limits need a positive number of requests and period, got %d per %s",
+			l.Requests, l.Period,
+		))
+	}
+}
+
+// rate is the number of tokens added to a bucket per second
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// capacity is the maximum number of tokens a bucket can hold
+func (l RateLimit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+
+	return float64(l.Requests)
+}
+
+// RateLimitResult is the state of a bucket after a request has been counted
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next request will be allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+/*
+RateLimitStore keeps track of token buckets. Implement it to share limits between
+multiple instances of an API, i.e. using Redis.
+*/
+type RateLimitStore interface {
+	// Take removes a token from the bucket identified by key, if one is available
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+// MemoryRateLimitStore keeps token buckets in memory for a single API instance
+type MemoryRateLimitStore struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket is a token bucket refilled continuously at the rate of its limit
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+// NewMemoryRateLimitStore creates an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Take implements RateLimitStore
+func (m *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.sweep(now)
+
+	existing, exists := m.buckets[key]
+	if !exists {
+		existing = &bucket{tokens: limit.capacity(), updated: now}
+		m.buckets[key] = existing
+	}
+
+	existing.limit = limit
+	existing.refill(now)
+
+	result := RateLimitResult{}
+	if existing.tokens >= 1 {
+		existing.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - existing.tokens) / limit.rate())
+	}
+
+	result.Remaining = int(existing.tokens)
+	result.Reset = seconds((limit.capacity() - existing.tokens) / limit.rate())
+
+	return result, nil
+}
+
+// sweep forgets buckets that have refilled completely, at most once a minute
+func (m *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+
+	for key, existing := range m.buckets {
+		existing.refill(now)
+		if existing.tokens >= existing.limit.capacity() {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// refill adds the tokens earned since the bucket was last updated
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.limit.capacity(), b.tokens+elapsed*b.limit.rate())
+		b.updated = now
+	}
+}
+
+// seconds converts a fractional number of seconds into a duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// RateLimitKey identifies the client a request is counted against
+type RateLimitKey func(r *http.Request) string
+
+// KeyByIP counts requests against the client's IP address
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// KeyByAPIKey counts requests against the API key found in the named header,
+// falling back to the client's IP address
+func KeyByAPIKey(header string) RateLimitKey {
+	return func(r *http.Request) string {
+		key := r.Header.Get(header)
+		if key == "" {
+			return "ip:" + KeyByIP(r)
+		}
+
+		return "key:" + key
+	}
+}
+
+// KeyByPrincipal counts requests against the authenticated caller, falling back
+// to the client's IP address. The Authenticator must run before the RateLimiter.
+func KeyByPrincipal(r *http.Request) string {
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		return "ip:" + KeyByIP(r)
+	}
+
+	return "principal:" + principal.ID
+}
+
+/*
+RateLimiter is a token bucket rate limiting middleware. Each client gets a bucket
+per resource and operation, so that a client listing one resource doesn't use up
+its allowance for another:
+
+	limiter := jshapi.NewRateLimiter(
+		jshapi.RateLimit{Requests: 100, Period: time.Minute},
+		jshapi.KeyByPrincipal,
+	)
+	limiter.Limit(jshapi.RateLimit{Requests: 10, Period: time.Minute}, jshapi.OpPost)
+	api.Use(auth.Middleware)
+	api.Use(limiter.Middleware)
+
+Rejected requests are sent as a 429 with a Retry-After header, and every response
+includes RateLimit-Limit, RateLimit-Remaining, and RateLimit-Reset headers.
+*/
+type RateLimiter struct {
+	Store RateLimitStore
+	Key   RateLimitKey
+	// Default applies to operations without their own limit
+	Default RateLimit
+	limits  map[Operation]RateLimit
+	// Now returns the current time, overridable for tests
+	Now func() time.Time
+}
+
+// NewRateLimiter creates a RateLimiter using a MemoryRateLimitStore. It panics if
+// limit doesn't allow a positive number of requests per positive period.
+func NewRateLimiter(limit RateLimit, key RateLimitKey) *RateLimiter {
+	limit.validate()
+
+	return &RateLimiter{
+		Store:   NewMemoryRateLimitStore(),
+		Key:     key,
+		Default: limit,
+		limits:  map[Operation]RateLimit{},
+		Now:     time.Now,
+	}
+}
+
+// Limit sets a different limit for one or more operations, panicking in the same
+// manner as NewRateLimiter
+func (l *RateLimiter) Limit(limit RateLimit, operations ...Operation) {
+	limit.validate()
+
+	for _, operation := range operations {
+		l.limits[operation] = limit
+	}
+}
+
+// Middleware can be registered via API.Use() or Resource.Use()
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		limit := l.Default
+		key := l.Key(r)
+
+		route, routed := RouteFromContext(ctx)
+		if routed {
+			if opLimit, exists := l.limits[route.Operation]; exists {
+				limit = opLimit
+			}
+			key = fmt.Sprintf("%s|%s|%s", key, route.ResourceType, route.Operation)
+		}
+
+		result, err := l.Store.Take(ctx, key, limit, l.Now())
+		if err != nil {
+			SendHandler(ctx, w, r, jsh.ISE(fmt.Sprintf("Error checking rate limit: %s", err.Error())))
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(int(limit.capacity())))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			retryAfter := ceilSeconds(result.RetryAfter)
+			header.Set("Retry-After", strconv.Itoa(retryAfter))
+			SendHandler(ctx, w, r, TooManyRequests(retryAfter))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// TooManyRequests creates a 429 error for rate limited requests
+func TooManyRequests(retryAfter int) *jsh.Error {
+	return &jsh.Error{
+		Title:  "Too Many Requests",
+		Detail: fmt.Sprintf("Rate limit exceeded, retry in %d seconds", retryAfter),
+		Status: http.StatusTooManyRequests,
+	}
+}
@@ -0,0 +1,117 @@
+package jshapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimiter(t *testing.T) {
+
+	Convey("RateLimiter Tests", t, func() {
+
+		now := time.Now()
+
+		limiter := NewRateLimiter(RateLimit{Requests: 2, Period: time.Minute}, KeyByIP)
+		limiter.Limit(RateLimit{Requests: 1, Period: time.Minute}, OpPatch)
+		limiter.Now = func() time.Time { return now }
+
+		api := New("api")
+		api.Use(limiter.Middleware)
+		api.Add(NewMockResource(testResourceType, 1, testObjAttrs))
+
+		server := httptest.NewServer(api)
+		defer server.Close()
+		baseURL := server.URL + api.prefix
+
+		Convey("should reject requests once the bucket is empty", func() {
+			for i := 0; i < 2; i++ {
+				_, resp, err := jsc.Fetch(baseURL, testResourceType, "1")
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("RateLimit-Limit"), ShouldEqual, "2")
+			}
+
+			doc, resp, err := jsc.Fetch(baseURL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusTooManyRequests)
+			So(resp.Header.Get("Retry-After"), ShouldEqual, "30")
+			So(resp.Header.Get("RateLimit-Remaining"), ShouldEqual, "0")
+			So(doc.Errors[0].Title, ShouldEqual, "Too Many Requests")
+
+			Convey("should keep separate buckets per operation", func() {
+				_, resp, err := jsc.List(baseURL, testResourceType)
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("should refill over time", func() {
+				now = now.Add(30 * time.Second)
+
+				_, resp, err := jsc.Fetch(baseURL, testResourceType, "1")
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("should use per operation limits", func() {
+			object := sampleObject("1", testResourceType, testObjAttrs)
+
+			_, resp, err := jsc.Patch(baseURL, object)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			_, resp, err = jsc.Patch(baseURL, object)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusTooManyRequests)
+		})
+
+		Convey("should reject limits without a positive rate", func() {
+			So(func() { NewRateLimiter(RateLimit{Requests: 1}, KeyByIP) }, ShouldPanic)
+			So(func() { limiter.Limit(RateLimit{Period: time.Second}, OpGet) }, ShouldPanic)
+		})
+	})
+}
+
+func TestRoute(t *testing.T) {
+
+	Convey("Route Tests", t, func() {
+
+		var route Route
+		api := New("api")
+		api.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				route, _ = RouteFromContext(r.Context())
+				next.ServeHTTP(w, r)
+			})
+		})
+
+		resource := NewMockResource(testResourceType, 1, testObjAttrs)
+		resource.ToMany("foo", func(ctx context.Context, id string) (jsh.List, error) {
+			return nil, nil
+		})
+		api.Add(resource)
+
+		server := httptest.NewServer(api)
+		defer server.Close()
+		baseURL := server.URL + api.prefix
+
+		Convey("should resolve the operation before middleware runs", func() {
+			_, _, err := jsc.Fetch(baseURL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			So(route.ResourceType, ShouldEqual, testResourceType)
+			So(route.Operation, ShouldEqual, OpGet)
+			So(route.Pattern, ShouldEqual, "GET /bars/{id}")
+
+			_, err = http.Get(baseURL + "/bars/1/relationships/foos")
+			So(err, ShouldBeNil)
+			So(route.Operation, ShouldEqual, ToManyOperation("foos"))
+		})
+	})
+}
@@ -1,5 +1,10 @@
 package jshapi
 
+import (
+	"fmt"
+	"strings"
+)
+
 // Relationship helps define the relationship between two resources
 type Relationship string
 
@@ -9,3 +14,17 @@ const (
 	// ToMany signifies a one to many relationship
 	ToMany Relationship = "One-To-Many"
 )
+
+// toOneName is the singular name a ToOne relationship is registered under
+func toOneName(resourceType string) string {
+	return strings.TrimSuffix(resourceType, "s")
+}
+
+// toManyName is the plural name a ToMany relationship is registered under
+func toManyName(resourceType string) string {
+	if !strings.HasSuffix(resourceType, "s") {
+		return fmt.Sprintf("%ss", resourceType)
+	}
+
+	return resourceType
+}
@@ -4,14 +4,9 @@ import (
 	"fmt"
 	"net/http"
 	"path"
-	"reflect"
 	"strings"
 
 	"goji.io"
-	"goji.io/pat"
-
-	"golang.org/x/net/context"
-
 	"github.com/derekdowling/go-json-spec-handler"
 	"github.com/derekdowling/jsh-api/store"
 )
@@ -20,7 +15,7 @@ const (
 	post    = "POST"
 	get     = "GET"
 	list    = "LIST"
-	delete  = "DELETE"
+	del     = "DELETE"
 	patch   = "PATCH"
 	patID   = "/:id"
 	patRoot = ""
@@ -36,25 +31,64 @@ of these endpoints that is also available through NewResource() and manually
 registering storage handlers via .Post(), .Get(), .List(), .Patch(), and .Delete():
 
 Besides the built in registration helpers, it isn't recommended, but you can add
-your own routes using the goji.Mux API:
+your own routes using the http.ServeMux API. Patterns include the resource type:
 
-	func searchHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		name := pat.Param(ctx, "name")
+	func searchHandler(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
 		fmt.Fprintf(w, "Hello, %s!", name)
 	}
 
 	resource := jshapi.NewCRUDResource("users", userStorage)
 	// creates /users/search/:name
-	resource.HandleC(pat.New("search/:name"), searchHandler)
+	resource.HandleFunc("GET /users/search/{name}", searchHandler)
 */
 type Resource struct {
-	*goji.Mux
+	*http.ServeMux
 	// The singular name of the resource type("user", "post", etc)
 	Type string
 	// Routes is a list of routes registered to the resource
 	Routes []string
 	// Map of relationships
 	Relationships map[string]Relationship
+	// includable are the relationships that can be included, see IncludeParam
+	includable map[string]*include
+	// Limits protects the resource from oversized request bodies
+	Limits BodyLimits
+	// bulkSave is used by `POST /resource` when a list of objects is sent
+	bulkSave store.BulkSave
+	// async is set when asynchronous storage handlers have been registered
+	async bool
+	// jobs runs pending asynchronous storage work, set by API.Add
+	jobs *JobQueue
+	// errors translates storage errors, set by API.Add
+	errors *ErrorMapper
+	// resources are the resources of the API, set by API.Add, whose policies
+	// apply to included objects
+	resources map[string]*Resource
+	// apiLimits are inherited from the API, set by API.Add
+	apiLimits *BodyLimits
+	// get is the registered Get storage, used to look up existing objects
+	get store.Get
+	// tenantAttribute stores the tenant of each object, see TenantScoped
+	tenantAttribute string
+	// middleware wraps every request handled by the resource
+	middleware []func(http.Handler) http.Handler
+	// handler is the ServeMux wrapped by middleware
+	handler http.Handler
+	// policies authorize operations, see Authorize
+	policies map[Operation]Authorizer
+	// scope narrows List results, see ScopeList
+	scope Scope
+	// attributes protects individual attributes, see AttributePolicy
+	attributes map[string]AttributePolicy
+	// operations maps registered patterns to the operation they perform
+	operations map[string]Operation
+	// auditSink records changes to objects, see Audit
+	auditSink AuditSink
+	// listeners are notified of changes to objects, see Listen
+	listeners []ChangeListener
+	// softDeletes makes deletes reversible, see SoftDelete
+	softDeletes *SoftDeletes
 }
 
 /*
@@ -65,21 +99,57 @@ managing routes and handling API calls.
 The prefix parameter causes all routes created within the resource to be prefixed.
 */
 func NewResource(resourceType string) *Resource {
+	mux := newServeMux()
+
 	return &Resource{
-		// Mux is a goji.SubMux, inherits context from parent Mux
-		Mux: goji.SubMux(),
+		// ServeMux routes using the full "/<type>/..." path
+		ServeMux: mux,
+		handler:  mux,
 		// Type of the resource, makes no assumptions about plurality
 		Type:          resourceType,
 		Relationships: map[string]Relationship{},
+		includable:    map[string]*include{},
+		policies:      map[Operation]Authorizer{},
+		attributes:    map[string]AttributePolicy{},
+		operations:    map[string]Operation{},
 		// A list of registered routes, useful for debugging
 		Routes: []string{},
 	}
 }
 
-// NewCRUDResource generates a resource
-func NewCRUDResource(resourceType string, storage store.CRUD) *Resource {
+// ServeHTTP implements http.Handler, enforcing BodyLimits and resolving the
+// request's Route before passing it through the resource's middleware
+func (res *Resource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limitErr := res.limitBody(r)
+	if limitErr != nil {
+		SendHandler(r.Context(), w, r, limitErr)
+		return
+	}
+
+	res.handler.ServeHTTP(w, withRoute(r, res.route))
+}
+
+/*
+Use appends a standard net/http middleware to the resource's middleware stack.
+Middleware are called in the order they are added, before the request is routed to
+the resource's handlers.
+*/
+func (res *Resource) Use(middleware func(http.Handler) http.Handler) {
+	res.middleware = append(res.middleware, middleware)
+	res.handler = chain(res.middleware, res.ServeMux)
+}
+
+// UseC appends context-aware goji middleware to the resource's middleware stack,
+// see GojiMiddleware
+func (res *Resource) UseC(middleware func(goji.Handler) goji.Handler) {
+	res.Use(GojiMiddleware(middleware))
+}
+
+// NewCRUDResource generates a resource, optionally decorating storage with
+// middleware as per CRUD
+func NewCRUDResource(resourceType string, storage store.CRUD, middleware ...store.Middleware) *Resource {
 	resource := NewResource(resourceType)
-	resource.CRUD(storage)
+	resource.CRUD(storage, middleware...)
 	return resource
 }
 
@@ -93,8 +163,24 @@ Registers handlers for:
 	GET    /resource/:id
 	DELETE /resource/:id
 	PATCH  /resource/:id
+
+Storage middleware is applied to every storage call, the first being the
+outermost:
+
+	resource.CRUD(storage, store.Logging(logger), breaker.Middleware, store.Timeout(time.Second))
+
+If storage is a store.Transactor, each mutating request to the resource is made a
+transaction, see Transactions.
 */
-func (res *Resource) CRUD(storage store.CRUD) {
+func (res *Resource) CRUD(storage store.CRUD, middleware ...store.Middleware) {
+	if transactor, ok := storage.(store.Transactor); ok {
+		res.Use(NewTransactions(transactor).Middleware)
+	}
+
+	if len(middleware) > 0 {
+		storage = store.NewStack(res.Type, middleware...).CRUD(storage)
+	}
+
 	res.Get(storage.Get)
 	res.Patch(storage.Update)
 	res.Post(storage.Save)
@@ -104,10 +190,11 @@ func (res *Resource) CRUD(storage store.CRUD) {
 
 // Post registers a `POST /resource` handler with the resource
 func (res *Resource) Post(storage store.Save) {
-	res.HandleFuncC(
-		pat.Post(patRoot),
-		func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-			res.postHandler(ctx, w, r, storage)
+	res.handle(
+		res.pattern(post, patRoot),
+		OpPost,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.postHandler(w, r, storage)
 		},
 	)
 
@@ -116,10 +203,13 @@ func (res *Resource) Post(storage store.Save) {
 
 // Get registers a `GET /resource/:id` handler for the resource
 func (res *Resource) Get(storage store.Get) {
-	res.HandleFuncC(
-		pat.Get(patID),
-		func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-			res.getHandler(ctx, w, r, storage)
+	res.get = storage
+
+	res.handle(
+		res.pattern(get, patID),
+		OpGet,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.getHandler(w, r, storage, OpGet)
 		},
 	)
 
@@ -128,10 +218,11 @@ func (res *Resource) Get(storage store.Get) {
 
 // List registers a `GET /resource` handler for the resource
 func (res *Resource) List(storage store.List) {
-	res.HandleFuncC(
-		pat.Get(patRoot),
-		func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-			res.listHandler(ctx, w, r, storage)
+	res.handle(
+		res.pattern(get, patRoot),
+		OpList,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.listHandler(w, r, storage)
 		},
 	)
 
@@ -140,22 +231,24 @@ func (res *Resource) List(storage store.List) {
 
 // Delete registers a `DELETE /resource/:id` handler for the resource
 func (res *Resource) Delete(storage store.Delete) {
-	res.HandleFuncC(
-		pat.Delete(patID),
-		func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-			res.deleteHandler(ctx, w, r, storage)
+	res.handle(
+		res.pattern(del, patID),
+		OpDelete,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.deleteHandler(w, r, storage)
 		},
 	)
 
-	res.addRoute(delete, patID)
+	res.addRoute(del, patID)
 }
 
 // Patch registers a `PATCH /resource/:id` handler for the resource
 func (res *Resource) Patch(storage store.Update) {
-	res.HandleFuncC(
-		pat.Patch(patID),
-		func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-			res.patchHandler(ctx, w, r, storage)
+	res.handle(
+		res.pattern(patch, patID),
+		OpPatch,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.patchHandler(w, r, storage)
 		},
 	)
 
@@ -165,7 +258,8 @@ func (res *Resource) Patch(storage store.Update) {
 // ToOne registers a `GET /resource/:id/(relationships/)<resourceType>` route which
 // returns a "resourceType" in a One-To-One relationship between the parent resource
 // type and "resourceType" as specified here. The "/relationships/" uri component is
-// optional.
+// optional. The relationship can also be included with `?include=<resourceType>`,
+// see BatchToOne to avoid calling storage once per included object.
 //
 // CRUD actions on a specific relationship "resourceType" object should be performed
 // via it's own top level /<resourceType> jsh-api handler as per JSONAPI specification.
@@ -173,21 +267,29 @@ func (res *Resource) ToOne(
 	resourceType string,
 	storage store.Get,
 ) {
-	resourceType = strings.TrimSuffix(resourceType, "s")
+	resourceType = toOneName(resourceType)
+	operation := ToOneOperation(resourceType)
 
 	res.relationshipHandler(
 		resourceType,
-		func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-			res.getHandler(ctx, w, r, storage)
+		operation,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.getHandler(w, r, storage, operation)
 		},
 	)
 
 	res.Relationships[resourceType] = ToOne
+	res.includable[resourceType] = &include{
+		name:      resourceType,
+		operation: operation,
+		load:      toOneInclude(storage),
+	}
 }
 
 // ToMany registers a `GET /resource/:id/(relationships/)<resourceType>s` route which
 // returns a list of "resourceType"s in a One-To-Many relationship with the parent resource.
-// The "/relationships/" uri component is optional.
+// The "/relationships/" uri component is optional. The relationship can also be
+// included with `?include=<resourceType>s`, see BatchToMany.
 //
 // CRUD actions on a specific relationship "resourceType" object should be performed
 // via it's own top level /<resourceType> jsh-api handler as per JSONAPI specification.
@@ -195,39 +297,47 @@ func (res *Resource) ToMany(
 	resourceType string,
 	storage store.ToMany,
 ) {
-	if !strings.HasSuffix(resourceType, "s") {
-		resourceType = fmt.Sprintf("%ss", resourceType)
-	}
+	resourceType = toManyName(resourceType)
+	operation := ToManyOperation(resourceType)
 
 	res.relationshipHandler(
 		resourceType,
-		func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-			res.toManyHandler(ctx, w, r, storage)
+		operation,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.toManyHandler(w, r, storage, operation)
 		},
 	)
 
 	res.Relationships[resourceType] = ToMany
+	res.includable[resourceType] = &include{
+		name:      resourceType,
+		operation: operation,
+		load:      toManyInclude(storage),
+	}
 }
 
 // relationshipHandler does the dirty work of setting up both routes for a single
 // relationship
 func (res *Resource) relationshipHandler(
 	resourceType string,
-	handler goji.HandlerFunc,
+	operation Operation,
+	handler http.HandlerFunc,
 ) {
 
 	// handle /.../:id/<resourceType>
 	matcher := fmt.Sprintf("%s/%s", patID, resourceType)
-	res.HandleFuncC(
-		pat.Get(matcher),
+	res.handle(
+		res.pattern(get, matcher),
+		operation,
 		handler,
 	)
 	res.addRoute(get, matcher)
 
 	// handle /.../:id/relationships/<resourceType>
 	relationshipMatcher := fmt.Sprintf("%s/relationships/%s", patID, resourceType)
-	res.HandleFuncC(
-		pat.Get(relationshipMatcher),
+	res.handle(
+		res.pattern(get, relationshipMatcher),
+		operation,
 		handler,
 	)
 	res.addRoute(get, relationshipMatcher)
@@ -237,11 +347,13 @@ func (res *Resource) relationshipHandler(
 // GET /(prefix/)resourceTypes/:id/<actionName> path format
 func (res *Resource) Action(actionName string, storage store.Get) {
 	matcher := path.Join(patID, actionName)
+	operation := ActionOperation(actionName)
 
-	res.HandleFuncC(
-		pat.Get(matcher),
-		func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-			res.actionHandler(ctx, w, r, storage)
+	res.handle(
+		res.pattern(get, matcher),
+		operation,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.actionHandler(w, r, storage, operation)
 		},
 	)
 
@@ -249,53 +361,169 @@ func (res *Resource) Action(actionName string, storage store.Get) {
 }
 
 // POST /resources
-func (res *Resource) postHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, storage store.Save) {
+func (res *Resource) postHandler(w http.ResponseWriter, r *http.Request, storage store.Save) {
+	if res.bulkSave != nil && (storage == nil || isBulkRequest(r)) {
+		res.bulkPostHandler(w, r, res.bulkSave)
+		return
+	}
+
+	ctx := r.Context()
+
 	parsedObject, parseErr := jsh.ParseObject(r)
-	if parseErr != nil && reflect.ValueOf(parseErr).IsNil() == false {
+	if parseErr != nil {
 		SendHandler(ctx, w, r, parseErr)
 		return
 	}
 
-	object, err := storage(ctx, parsedObject)
-	if err != nil && reflect.ValueOf(err).IsNil() == false {
-		SendHandler(ctx, w, r, err)
+	if !res.authorize(w, r, OpPost, Target{ID: parsedObject.ID, Object: parsedObject}) {
+		return
+	}
+
+	mutation := res.observeMutation(ctx, OpPost)
+
+	storageCtx, observe := res.instrument(ctx, parsedObject.ID)
+	object, err := storage(storageCtx, parsedObject)
+	observe(err)
+	sendableErr := res.storageError(err, parsedObject.ID)
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
+		return
+	}
+
+	recordErr := mutation.record(ctx, object)
+	if recordErr != nil {
+		SendHandler(ctx, w, r, recordErr)
 		return
 	}
 
-	SendHandler(ctx, w, r, object)
+	res.send(w, r, object)
 }
 
 // GET /resources/:id
-func (res *Resource) getHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, storage store.Get) {
-	id := pat.Param(ctx, "id")
+func (res *Resource) getHandler(w http.ResponseWriter, r *http.Request, storage store.Get, operation Operation) {
+	ctx := r.Context()
+
+	id := r.PathValue("id")
+	if !res.authorize(w, r, operation, Target{ID: id}) {
+		return
+	}
+
+	var includes []*include
+	if operation == OpGet {
+		var includeErr jsh.ErrorType
+		includes, includeErr = res.includes(r)
+		if includeErr != nil {
+			SendHandler(ctx, w, r, includeErr)
+			return
+		}
+	}
+
+	storageCtx, observe := res.instrument(ctx, id)
+	object, err := storage(storageCtx, id)
+	observe(err)
+	sendableErr := res.storageError(err, id)
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
+		return
+	}
+
+	if operation == OpGet {
+		tenantErr := res.tenantRead(ctx, object)
+		if tenantErr != nil {
+			SendHandler(ctx, w, r, tenantErr)
+			return
+		}
+
+		deletedErr := res.deletedRead(object)
+		if deletedErr != nil {
+			SendHandler(ctx, w, r, deletedErr)
+			return
+		}
+	}
 
-	object, err := storage(ctx, id)
-	if err != nil && reflect.ValueOf(err).IsNil() == false {
-		SendHandler(ctx, w, r, err)
+	if len(includes) > 0 {
+		res.sendIncluded(w, r, object, includes)
 		return
 	}
 
-	SendHandler(ctx, w, r, object)
+	res.send(w, r, object)
 }
 
 // GET /resources
-func (res *Resource) listHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, storage store.List) {
-	list, err := storage(ctx)
-	if err != nil && reflect.ValueOf(err).IsNil() == false {
-		SendHandler(ctx, w, r, err)
+func (res *Resource) listHandler(w http.ResponseWriter, r *http.Request, storage store.List) {
+	ctx := r.Context()
+
+	if !res.authorize(w, r, OpList, Target{}) {
+		return
+	}
+
+	includes, includeErr := res.includes(r)
+	if includeErr != nil {
+		SendHandler(ctx, w, r, includeErr)
+		return
+	}
+
+	showDeleted, deletedErr := res.showDeleted(r)
+	if deletedErr != nil {
+		SendHandler(ctx, w, r, deletedErr)
+		return
+	}
+
+	storageCtx, observe := res.instrument(store.WithQuery(ctx, r.URL.Query()), "")
+	list, err := storage(storageCtx)
+	observe(err)
+	sendableErr := res.storageError(err, "")
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
 		return
 	}
 
-	SendHandler(ctx, w, r, list)
+	list, err = res.scopeList(ctx, list)
+	sendableErr = res.storageError(err, "")
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
+		return
+	}
+
+	if !showDeleted {
+		list = res.deletedList(list)
+	}
+
+	if len(includes) > 0 {
+		res.sendIncluded(w, r, list, includes)
+		return
+	}
+
+	res.send(w, r, list)
 }
 
 // DELETE /resources/:id
-func (res *Resource) deleteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, storage store.Delete) {
-	id := pat.Param(ctx, "id")
+func (res *Resource) deleteHandler(w http.ResponseWriter, r *http.Request, storage store.Delete) {
+	ctx := r.Context()
+
+	id := r.PathValue("id")
+	if !res.authorize(w, r, OpDelete, Target{ID: id}) {
+		return
+	}
+
+	if res.softDeletes != nil {
+		storage = store.Delete(res.softDeletes.Delete)
+	}
+
+	mutation := res.observeMutation(ctx, OpDelete, id)
+
+	storageCtx, observe := res.instrument(ctx, id)
+	err := storage(storageCtx, id)
+	observe(err)
+	sendableErr := res.storageError(err, id)
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
+		return
+	}
 
-	err := storage(ctx, id)
-	if err != nil && reflect.ValueOf(err).IsNil() == false {
-		SendHandler(ctx, w, r, err)
+	recordErr := mutation.record(ctx)
+	if recordErr != nil {
+		SendHandler(ctx, w, r, recordErr)
 		return
 	}
 
@@ -303,52 +531,93 @@ func (res *Resource) deleteHandler(ctx context.Context, w http.ResponseWriter, r
 }
 
 // PATCH /resources/:id
-func (res *Resource) patchHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, storage store.Update) {
+func (res *Resource) patchHandler(w http.ResponseWriter, r *http.Request, storage store.Update) {
+	ctx := r.Context()
+
 	parsedObject, parseErr := jsh.ParseObject(r)
-	if parseErr != nil && reflect.ValueOf(parseErr).IsNil() == false {
+	if parseErr != nil {
 		SendHandler(ctx, w, r, parseErr)
 		return
 	}
 
-	id := pat.Param(ctx, "id")
+	id := r.PathValue("id")
 	if id != parsedObject.ID {
 		SendHandler(ctx, w, r, jsh.InputError("Request ID does not match URL's", "id"))
 		return
 	}
 
-	object, err := storage(ctx, parsedObject)
-	if err != nil && reflect.ValueOf(err).IsNil() == false {
-		SendHandler(ctx, w, r, err)
+	if !res.authorize(w, r, OpPatch, Target{ID: id, Object: parsedObject}) {
 		return
 	}
 
-	SendHandler(ctx, w, r, object)
+	mutation := res.observeMutation(ctx, OpPatch, id)
+
+	storageCtx, observe := res.instrument(ctx, parsedObject.ID)
+	object, err := storage(storageCtx, parsedObject)
+	observe(err)
+	sendableErr := res.storageError(err, id)
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
+		return
+	}
+
+	recordErr := mutation.record(ctx, object)
+	if recordErr != nil {
+		SendHandler(ctx, w, r, recordErr)
+		return
+	}
+
+	res.send(w, r, object)
 }
 
 // GET /resources/:id/(relationships/)<resourceType>s
-func (res *Resource) toManyHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, storage store.ToMany) {
-	id := pat.Param(ctx, "id")
+func (res *Resource) toManyHandler(w http.ResponseWriter, r *http.Request, storage store.ToMany, operation Operation) {
+	ctx := r.Context()
+
+	id := r.PathValue("id")
+	if !res.authorize(w, r, operation, Target{ID: id}) {
+		return
+	}
 
-	list, err := storage(ctx, id)
-	if err != nil && reflect.ValueOf(err).IsNil() == false {
-		SendHandler(ctx, w, r, err)
+	storageCtx, observe := res.instrument(ctx, id)
+	list, err := storage(storageCtx, id)
+	observe(err)
+	sendableErr := res.storageError(err, id)
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
 		return
 	}
 
-	SendHandler(ctx, w, r, list)
+	res.send(w, r, list)
 }
 
 // All HTTP Methods for /resources/:id/<mutate>
-func (res *Resource) actionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, storage store.Get) {
-	id := pat.Param(ctx, "id")
+func (res *Resource) actionHandler(w http.ResponseWriter, r *http.Request, storage store.Get, operation Operation) {
+	ctx := r.Context()
+
+	id := r.PathValue("id")
+	if !res.authorize(w, r, operation, Target{ID: id}) {
+		return
+	}
+
+	mutation := res.observeMutation(ctx, operation, id)
+
+	storageCtx, observe := res.instrument(ctx, id)
+	response, err := storage(storageCtx, id)
+	observe(err)
+	sendableErr := res.storageError(err, id)
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
+		return
+	}
 
-	response, err := storage(ctx, id)
-	if err != nil && reflect.ValueOf(err).IsNil() == false {
-		SendHandler(ctx, w, r, err)
+	recordErr := mutation.record(ctx, response)
+	if recordErr != nil {
+		SendHandler(ctx, w, r, recordErr)
 		return
 	}
 
-	SendHandler(ctx, w, r, response)
+	res.send(w, r, response)
 }
 
 // addRoute adds the new method and route to a route Tree for debugging and
@@ -357,6 +626,26 @@ func (res *Resource) addRoute(method string, route string) {
 	res.Routes = append(res.Routes, fmt.Sprintf("%s - /%s%s", method, res.Type, route))
 }
 
+// pattern builds the http.ServeMux pattern for a method and route relative to the
+// resource, i.e. "/:id" becomes "GET /<type>/{id}"
+func (res *Resource) pattern(method string, route string) string {
+	route = strings.Replace(route, ":id", "{id}", -1)
+	return fmt.Sprintf("%s /%s%s", method, res.Type, route)
+}
+
+// hasRoute checks whether a method and route have already been registered
+func (res *Resource) hasRoute(method string, route string) bool {
+	registered := fmt.Sprintf("%s - /%s%s", method, res.Type, route)
+
+	for _, existing := range res.Routes {
+		if existing == registered {
+			return true
+		}
+	}
+
+	return false
+}
+
 // RouteTree prints a recursive route tree based on what the resource, and
 // all subresources have registered
 func (res *Resource) RouteTree() string {
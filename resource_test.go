@@ -1,16 +1,17 @@
 package jshapi
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/derekdowling/go-json-spec-handler"
 	"github.com/derekdowling/go-json-spec-handler/client"
 	. "github.com/smartystreets/goconvey/convey"
-	"golang.org/x/net/context"
 )
 
 func TestResource(t *testing.T) {
@@ -106,7 +107,7 @@ func TestActionHandler(t *testing.T) {
 	resource := NewMockResource(testResourceType, 2, testObjAttrs)
 
 	// Add our custom action
-	handler := func(ctx context.Context, id string) (*jsh.Object, jsh.ErrorType) {
+	handler := func(ctx context.Context, id string) (*jsh.Object, error) {
 		object := sampleObject(id, testResourceType, testObjAttrs)
 		return object, nil
 	}
@@ -139,7 +140,7 @@ func TestToOne(t *testing.T) {
 
 	resource := NewMockResource(testResourceType, 2, testObjAttrs)
 
-	relationshipHandler := func(ctx context.Context, resourceID string) (*jsh.Object, jsh.ErrorType) {
+	relationshipHandler := func(ctx context.Context, resourceID string) (*jsh.Object, error) {
 		return sampleObject("1", "baz", map[string]string{"baz": "ball"}), nil
 	}
 
@@ -191,7 +192,7 @@ func TestToMany(t *testing.T) {
 
 	resource := NewMockResource(testResourceType, 2, testObjAttrs)
 
-	relationshipHandler := func(ctx context.Context, resourceID string) (jsh.List, jsh.ErrorType) {
+	relationshipHandler := func(ctx context.Context, resourceID string) (jsh.List, error) {
 		return jsh.List{
 			sampleObject("1", "baz", map[string]string{"baz": "ball"}),
 			sampleObject("2", "baz", map[string]string{"baz": "ball2"}),
@@ -243,3 +244,57 @@ func TestToMany(t *testing.T) {
 		})
 	})
 }
+
+// BenchmarkGetHandler measures a full `GET /resources/:id` request against
+// MockStorage, which deliberately returns a typed nil error
+func BenchmarkGetHandler(b *testing.B) {
+	resource := NewMockResource(testResourceType, 1, testObjAttrs)
+	benchmarkGet(b, resource)
+}
+
+// BenchmarkGetHandlerReflect measures the same request using the reflection based
+// nil check handlers used before normalizeError
+func BenchmarkGetHandlerReflect(b *testing.B) {
+	mock := &MockStorage{
+		ResourceType:       testResourceType,
+		ResourceAttributes: testObjAttrs,
+	}
+
+	resource := NewResource(testResourceType)
+	resource.HandleFunc(
+		resource.pattern(get, patID),
+		func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			object, err := mock.Get(ctx, r.PathValue("id"))
+			if err != nil && reflect.ValueOf(err).IsNil() == false {
+				SendHandler(ctx, w, r, resource.storageError(err, ""))
+				return
+			}
+
+			SendHandler(ctx, w, r, object)
+		},
+	)
+
+	benchmarkGet(b, resource)
+}
+
+func benchmarkGet(b *testing.B, resource *Resource) {
+	api := New("")
+	api.Add(resource)
+
+	request, err := jsc.FetchRequest("http://localhost", testResourceType, "1")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusOK {
+			b.Fatalf("Unexpected status: %d", recorder.Code)
+		}
+	}
+}
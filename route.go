@@ -0,0 +1,135 @@
+package jshapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+const routeKey = contextKey("route")
+
+/*
+Route describes which resource and operation a request has been routed to. It is
+resolved before any middleware runs, so that middleware such as a RateLimiter can
+treat operations differently:
+
+	route, ok := jshapi.RouteFromContext(r.Context())
+	if ok && route.Operation == jshapi.OpDelete {
+		...
+	}
+*/
+type Route struct {
+	// ResourceType is the type of the resource handling the request
+	ResourceType string
+	// Operation is the operation registered for the matched pattern
+	Operation Operation
+	// Pattern is the http.ServeMux pattern that matched, relative to the API
+	// prefix, i.e. "GET /users/{id}"
+	Pattern string
+}
+
+// RouteFromContext retrieves the route resolved for a request
+func RouteFromContext(ctx context.Context) (Route, bool) {
+	route, ok := ctx.Value(routeKey).(Route)
+	return route, ok
+}
+
+// handle registers a handler for a pattern built via .pattern(), recording which
+// operation it performs
+func (res *Resource) handle(pattern string, operation Operation, handler http.HandlerFunc) {
+	res.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		recordAccess(r)
+		handler(w, r)
+	})
+	res.operations[pattern] = operation
+}
+
+// route resolves the operation a request will be routed to within the resource
+func (res *Resource) route(r *http.Request) (Route, bool) {
+	_, pattern := res.ServeMux.Handler(r)
+
+	operation, exists := res.operations[pattern]
+	if !exists {
+		return Route{}, false
+	}
+
+	return Route{
+		ResourceType: res.Type,
+		Operation:    operation,
+		Pattern:      pattern,
+	}, true
+}
+
+// route resolves the resource and operation a request will be routed to
+func (a *API) route(r *http.Request) (Route, bool) {
+	_, pattern := a.ServeMux.Handler(r)
+	if pattern == "" {
+		return Route{}, false
+	}
+
+	resourceType := strings.Trim(strings.TrimPrefix(pattern, a.prefix), "/")
+	resource, exists := a.Resources[resourceType]
+	if !exists {
+		return Route{}, false
+	}
+
+	// resources route using paths without the API prefix
+	relative := new(http.Request)
+	*relative = *r
+	relative.URL = new(url.URL)
+	*relative.URL = *r.URL
+	relative.URL.Path = path.Join("/", strings.TrimPrefix(r.URL.Path, a.prefix))
+	if strings.HasSuffix(r.URL.Path, "/") {
+		relative.URL.Path += "/"
+	}
+	relative.URL.RawPath = ""
+
+	return resource.route(relative)
+}
+
+// withRoute stores the route resolved by resolve within the request's context,
+// unless one has already been resolved
+func withRoute(r *http.Request, resolve func(*http.Request) (Route, bool)) *http.Request {
+	ctx := r.Context()
+	if _, resolved := RouteFromContext(ctx); resolved {
+		return r
+	}
+
+	route, found := resolve(r)
+	if !found {
+		return r
+	}
+
+	return r.WithContext(context.WithValue(ctx, routeKey, route))
+}
+
+var (
+	legacyMuxOnce sync.Once
+	legacyMux     bool
+)
+
+/*
+newServeMux creates the mux an API or resource routes with. It panics if Go 1.21's
+ServeMux behavior is in effect, as it is by default in GOPATH mode, since patterns
+such as "GET /users/{id}" would then be matched as literal paths and nothing would
+be routed.
+*/
+func newServeMux() *http.ServeMux {
+	legacyMuxOnce.Do(func() {
+		probe := http.NewServeMux()
+		probe.HandleFunc("GET /{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+		_, pattern := probe.Handler(&http.Request{Method: get, URL: &url.URL{Path: "/probe"}})
+		legacyMux = pattern == ""
+	})
+
+	if legacyMux {
+		panic("jshapi: http.ServeMux patterns are disabled, build with Go 1.22 module " +
+			"semantics or set `//go:debug httpmuxgo121=0` in your main package")
+	}
+
+	return http.NewServeMux()
+}
@@ -1,11 +1,11 @@
 package jshapi
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/derekdowling/go-json-spec-handler"
 	"github.com/derekdowling/go-stdlogger"
-	"golang.org/x/net/context"
 )
 
 /*
@@ -25,9 +25,19 @@ func DefaultSender(logger std.Logger) Sender {
 			logger.Printf("Returning ISE: %s\n", sendableError.Error())
 		}
 
-		sendError := jsh.Send(w, r, sendable)
+		sendError := sendPayload(w, r, sendable)
 		if sendError != nil && sendError.Status >= 500 {
 			logger.Printf("Error sending response: %s\n", sendError.Error())
 		}
 	}
 }
+
+// sendPayload sends a response, handing documents built by jshapi to
+// jsh.SendDocument as jsh.Send only builds objects, lists, and errors
+func sendPayload(w http.ResponseWriter, r *http.Request, sendable jsh.Sendable) *jsh.Error {
+	if document, isDocument := sendable.(*jsh.Document); isDocument {
+		return jsh.SendDocument(w, r, document)
+	}
+
+	return jsh.Send(w, r, sendable)
+}
@@ -0,0 +1,229 @@
+package jshapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/jsh-api/store"
+)
+
+// DeletedFilter is the query parameter privileged callers set to "true" to list
+// soft deleted objects along with the rest
+const DeletedFilter = "filter[deleted]"
+
+// SoftDeletes configures soft deletion for a resource, see SoftDelete
+type SoftDeletes struct {
+	// Attribute is set on deleted objects, and null or missing otherwise
+	Attribute string
+	// Delete marks an object as deleted
+	Delete store.SoftDelete
+	// Restore undoes Delete
+	Restore store.Restore
+	// Gone sends a 410 rather than a 404 for deleted objects
+	Gone bool
+	// ShowDeleted decides whether the caller may list deleted objects via
+	// `filter[deleted]=true`. A nil ShowDeleted allows nobody.
+	ShowDeleted func(ctx context.Context, principal *Principal) bool
+}
+
+/*
+SoftDelete makes deletes reversible. `DELETE /resources/:id` marks objects as
+deleted using the Delete storage rather than removing them, after which they are
+reported as not found (or gone) by `GET /resources/:id` and left out of lists.
+Deleted objects can't be updated, deleted again, or have their relationships
+read, and clients can't write the Attribute themselves.
+`POST /resources/:id/restore` is registered to undo a delete, responding with a 200
+and the restored object. Its policy can be set via OpRestore:
+
+	users.SoftDelete(jshapi.SoftDeletes{
+		Attribute:   "deletedAt",
+		Delete:      store.MarkDeleted(storage.Update, "users", "deletedAt"),
+		Restore:     store.UnmarkDeleted(storage.Update, "users", "deletedAt"),
+		ShowDeleted: func(ctx context.Context, principal *jshapi.Principal) bool {
+			return principal != nil && principal.HasRole("admin")
+		},
+	})
+	users.Authorize(adminsOnly, jshapi.OpRestore)
+
+SoftDelete must be called after CRUD or Delete, whose storage is then no longer
+called. Bulk deletes soft delete each object in turn. It panics if Attribute,
+Delete, or Restore isn't set.
+*/
+func (res *Resource) SoftDelete(options SoftDeletes) {
+	options.validate(res.Type)
+	res.softDeletes = &options
+
+	if !res.hasRoute(del, patID) {
+		res.Delete(nil)
+	}
+
+	matcher := patID + "/restore"
+	res.handle(
+		res.pattern(post, matcher),
+		OpRestore,
+		func(w http.ResponseWriter, r *http.Request) {
+			res.restoreHandler(w, r, options.Restore)
+		},
+	)
+	res.addRoute(post, matcher)
+}
+
+// POST /resources/:id/restore
+func (res *Resource) restoreHandler(w http.ResponseWriter, r *http.Request, storage store.Restore) {
+	ctx := r.Context()
+
+	id := r.PathValue("id")
+	if !res.authorize(w, r, OpRestore, Target{ID: id}) {
+		return
+	}
+
+	mutation := res.observeMutation(ctx, OpRestore, id)
+
+	storageCtx, observe := res.instrument(ctx, id)
+	object, err := storage(storageCtx, id)
+	observe(err)
+	sendableErr := res.storageError(err, id)
+	if sendableErr != nil {
+		SendHandler(ctx, w, r, sendableErr)
+		return
+	}
+
+	recordErr := mutation.record(ctx, object)
+	if recordErr != nil {
+		SendHandler(ctx, w, r, recordErr)
+		return
+	}
+
+	if object == nil {
+		SendHandler(ctx, w, r, jsh.NotFound(res.Type, id))
+		return
+	}
+
+	// restoring doesn't create an object, so it is sent with a 200 rather than
+	// the 201 jsh expects of a POST
+	document := jsh.Build(object)
+	document.Status = http.StatusOK
+	res.send(w, r, document)
+}
+
+// validate rejects soft deletes that couldn't delete or restore objects
+func (s *SoftDeletes) validate(resourceType string) {
+	if s.Attribute == "" || s.Delete == nil || s.Restore == nil {
+		panic(fmt.Sprintf(
+			"jshapi: soft deletes for '%s' need an Attribute, and Delete and Restore storage",
+			resourceType,
+		))
+	}
+}
+
+// deleteAll soft deletes each object of a bulk delete, stopping at the first error
+func (s *SoftDeletes) deleteAll(ctx context.Context, ids []string) error {
+	for _, id := range ids {
+		err := s.Delete(ctx, id)
+		if normalizeError(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deletedRead reports soft deleted objects as not found, or gone
+func (res *Resource) deletedRead(object *jsh.Object) jsh.ErrorType {
+	if res.softDeletes == nil || object == nil || object.Type != res.Type {
+		return nil
+	}
+
+	if !store.IsDeleted(object, res.softDeletes.Attribute) {
+		return nil
+	}
+
+	if res.softDeletes.Gone {
+		return &jsh.Error{
+			Title:  "Gone",
+			Detail: fmt.Sprintf("The %s with ID %s has been deleted", res.Type, object.ID),
+			Status: http.StatusGone,
+		}
+	}
+
+	return jsh.NotFound(res.Type, object.ID)
+}
+
+/*
+deletedTarget reports the object targeted by an operation as not found, or gone,
+if it has been soft deleted. Deleted objects can only be listed and restored, so
+the object is fetched before any other operation on it.
+*/
+func (res *Resource) deletedTarget(ctx context.Context, operation Operation, target Target) jsh.ErrorType {
+	if res.softDeletes == nil || res.get == nil || target.ID == "" {
+		return nil
+	}
+
+	// fetched objects are checked by deletedRead, and lists by deletedList
+	switch operation {
+	case OpGet, OpList, OpPost, OpRestore:
+		return nil
+	}
+
+	storageCtx, observe := res.instrument(ctx, target.ID)
+	existing, err := res.get(storageCtx, target.ID)
+	observe(err)
+	sendableErr := res.storageError(err, target.ID)
+	if sendableErr != nil {
+		return sendableErr
+	}
+
+	return res.deletedRead(existing)
+}
+
+// deletedWrite rejects objects written with the soft delete attribute, which is
+// only changed by deleting and restoring objects
+func (res *Resource) deletedWrite(object *jsh.Object) jsh.ErrorType {
+	if res.softDeletes == nil {
+		return nil
+	}
+
+	attributes, _ := objectAttributes(object)
+	if _, set := attributes[res.softDeletes.Attribute]; !set {
+		return nil
+	}
+
+	deletedErr := Forbidden("Objects can only be deleted and restored through their routes")
+	deletedErr.Source.Pointer = fmt.Sprintf("/data/attributes/%s", res.softDeletes.Attribute)
+	return deletedErr
+}
+
+// showDeleted checks whether a list request asks for soft deleted objects, and
+// whether the caller may see them
+func (res *Resource) showDeleted(r *http.Request) (bool, jsh.ErrorType) {
+	if res.softDeletes == nil || r.URL.Query().Get(DeletedFilter) != "true" {
+		return false, nil
+	}
+
+	ctx := r.Context()
+	principal, _ := PrincipalFromContext(ctx)
+
+	if res.softDeletes.ShowDeleted == nil || !res.softDeletes.ShowDeleted(ctx, principal) {
+		return false, Forbidden("Deleted objects can't be listed")
+	}
+
+	return true, nil
+}
+
+// deletedList leaves soft deleted objects out of a list
+func (res *Resource) deletedList(list jsh.List) jsh.List {
+	if res.softDeletes == nil {
+		return list
+	}
+
+	kept := jsh.List{}
+	for _, object := range list {
+		if object.Type != res.Type || !store.IsDeleted(object, res.softDeletes.Attribute) {
+			kept = append(kept, object)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,165 @@
+package jshapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	"github.com/derekdowling/jsh-api/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSoftDelete(t *testing.T) {
+
+	Convey("Soft Delete Tests", t, func() {
+
+		storage := store.NewMemory(testResourceType)
+		options := SoftDeletes{
+			Attribute: "deletedAt",
+			Delete:    store.MarkDeleted(storage.Update, testResourceType, "deletedAt"),
+			Restore:   store.UnmarkDeleted(storage.Update, testResourceType, "deletedAt"),
+			ShowDeleted: func(ctx context.Context, principal *Principal) bool {
+				return principal != nil && principal.HasRole("admin")
+			},
+		}
+
+		resource := NewCRUDResource(testResourceType, storage)
+		resource.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Admin") != "" {
+					r = r.WithContext(WithPrincipal(r.Context(), &Principal{ID: "1", Roles: []string{"admin"}}))
+				}
+				next.ServeHTTP(w, r)
+			})
+		})
+
+		setup := func() *httptest.Server {
+			resource.SoftDelete(options)
+
+			server := httptest.NewServer(resource)
+
+			for i := 0; i < 2; i++ {
+				_, resp, err := jsc.Post(server.URL, sampleObject("", testResourceType, testObjAttrs))
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+			}
+
+			request, err := jsc.DeleteRequest(server.URL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			resp, err := http.DefaultClient.Do(request)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+
+			return server
+		}
+
+		list := func(server *httptest.Server, admin bool) (*jsh.Document, *http.Response) {
+			request, err := jsc.ListRequest(server.URL, testResourceType)
+			So(err, ShouldBeNil)
+			request.URL.RawQuery = DeletedFilter + "=true"
+			if admin {
+				request.Header.Set("X-Admin", "true")
+			}
+
+			doc, resp, err := jsc.Do(request, jsh.ListMode)
+			So(err, ShouldBeNil)
+			return doc, resp
+		}
+
+		Convey("should require Delete and Restore storage", func() {
+			options.Restore = nil
+			So(func() { resource.SoftDelete(options) }, ShouldPanic)
+		})
+
+		Convey("should keep deleted objects in storage", func() {
+			server := setup()
+			defer server.Close()
+
+			object, err := storage.Get(context.Background(), "1")
+			So(err, ShouldBeNil)
+			So(store.IsDeleted(object, "deletedAt"), ShouldBeTrue)
+
+			_, resp, fetchErr := jsc.Fetch(server.URL, testResourceType, "1")
+			So(fetchErr, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			doc, resp, listErr := jsc.List(server.URL, testResourceType)
+			So(listErr, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(doc.Data, ShouldHaveLength, 1)
+			So(doc.Data[0].ID, ShouldEqual, "2")
+		})
+
+		Convey("should send a 410 when configured", func() {
+			options.Gone = true
+			server := setup()
+			defer server.Close()
+
+			_, resp, err := jsc.Fetch(server.URL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusGone)
+		})
+
+		Convey("should only list deleted objects for privileged callers", func() {
+			server := setup()
+			defer server.Close()
+
+			_, resp := list(server, false)
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+
+			doc, resp := list(server, true)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(doc.Data, ShouldHaveLength, 2)
+		})
+
+		Convey("should not change deleted objects", func() {
+			server := setup()
+			defer server.Close()
+
+			_, resp, err := jsc.Patch(server.URL, sampleObject("1", testResourceType, testObjAttrs))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			request, err := jsc.DeleteRequest(server.URL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			resp, err = http.DefaultClient.Do(request)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("should reject writes to the deleted attribute", func() {
+			server := setup()
+			defer server.Close()
+
+			undelete := sampleObject("1", testResourceType, map[string]interface{}{"deletedAt": nil})
+			_, resp, err := jsc.Patch(server.URL, undelete)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			deleted := sampleObject("2", testResourceType, map[string]string{"deletedAt": "now"})
+			_, resp, err = jsc.Patch(server.URL, deleted)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+
+			_, resp, err = jsc.Post(server.URL, sampleObject("", testResourceType, map[string]string{"deletedAt": "now"}))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("should restore deleted objects", func() {
+			server := setup()
+			defer server.Close()
+
+			resp, err := http.Post(server.URL+"/"+testResourceType+"/1/restore", jsh.ContentType, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			_, resp, fetchErr := jsc.Fetch(server.URL, testResourceType, "1")
+			So(fetchErr, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		})
+	})
+}
@@ -0,0 +1,394 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+// DefaultCacheEntries bounds a Cache created without MaxEntries
+const DefaultCacheEntries = 1024
+
+/*
+Cache is a read-through cache for Get and List storage. Objects are cached by type
+and ID, and lists by their normalized query (see WithQuery), both separately for
+each tenant. Writes made through the same Cache invalidate what they change: Save
+invalidates lists, while Update and Delete invalidate lists and the object.
+
+	cache := store.NewCache("users", store.CacheOptions{TTL: time.Minute})
+	resource := jshapi.NewCRUDResource("users", cache.CRUD(storage))
+
+Individual functions can be wrapped instead, as long as writes go through the
+cache too:
+
+	resource.Get(cache.Get(storage.Get))
+	resource.Patch(cache.Update(storage.Update))
+
+Concurrent misses for the same key are coalesced into a single backend call, and
+errors are never cached. The backend is called with the context of the first miss,
+so if that request is cancelled the others retry with their own.
+*/
+type Cache struct {
+	resourceType string
+	options      CacheOptions
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	// recent orders entries from most to least recently used
+	recent *list.List
+	// lists are the keys of cached lists, invalidated by every write
+	lists map[string]bool
+	// objects are the keys of cached objects by ID, one per tenant
+	objects map[string]map[string]bool
+	// generation changes on every invalidation, so misses in flight at the time
+	// aren't cached
+	generation uint64
+	// flights are the backend calls in progress, by key
+	flights map[string]*flight
+}
+
+// CacheOptions configure a Cache
+type CacheOptions struct {
+	// TTL expires entries, zero keeps them until evicted or invalidated
+	TTL time.Duration
+	// MaxEntries evicts the least recently used entries, defaults to
+	// DefaultCacheEntries
+	MaxEntries int
+	// Now defaults to time.Now
+	Now func() time.Time
+}
+
+// cacheEntry is a single cached object or list
+type cacheEntry struct {
+	key     string
+	id      string
+	object  *jsh.Object
+	list    jsh.List
+	expires time.Time
+}
+
+// flight is a backend call shared by concurrent misses
+type flight struct {
+	done   chan struct{}
+	object *jsh.Object
+	list   jsh.List
+	err    error
+	// cancelled is set when err came from the context of the caller that made
+	// the call, rather than the backend
+	cancelled bool
+}
+
+// NewCache creates an empty Cache for a resource type
+func NewCache(resourceType string, options CacheOptions) *Cache {
+	if options.MaxEntries <= 0 {
+		options.MaxEntries = DefaultCacheEntries
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+
+	return &Cache{
+		resourceType: resourceType,
+		options:      options,
+		entries:      map[string]*list.Element{},
+		recent:       list.New(),
+		lists:        map[string]bool{},
+		objects:      map[string]map[string]bool{},
+		flights:      map[string]*flight{},
+	}
+}
+
+// CRUD wraps every function of storage with the cache
+func (c *Cache) CRUD(storage CRUD) CRUD {
+	return &funcCRUD{
+		save:   c.Save(storage.Save),
+		get:    c.Get(storage.Get),
+		list:   c.List(storage.List),
+		update: c.Update(storage.Update),
+		delete: c.Delete(storage.Delete),
+	}
+}
+
+// Get caches objects by ID
+func (c *Cache) Get(get Get) Get {
+	return func(ctx context.Context, id string) (*jsh.Object, error) {
+		key := c.key(ctx, "get", id)
+
+		called, err := c.fetch(ctx, key, id, func(call *flight) {
+			call.object, call.err = get(ctx, id)
+		})
+		if err != nil || called.object == nil {
+			return nil, err
+		}
+
+		return copyObject(called.object), nil
+	}
+}
+
+// List caches lists by their normalized query
+func (c *Cache) List(list List) List {
+	return func(ctx context.Context) (jsh.List, error) {
+		query, _ := QueryFromContext(ctx)
+		key := c.key(ctx, "list", NormalizeQuery(query))
+
+		called, err := c.fetch(ctx, key, "", func(call *flight) {
+			call.list, call.err = list(ctx)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return copyList(called.list), nil
+	}
+}
+
+// Save invalidates cached lists once an object has been saved
+func (c *Cache) Save(save Save) Save {
+	return func(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+		saved, err := save(ctx, object)
+		if !failed(err) {
+			c.Invalidate("")
+		}
+
+		return saved, err
+	}
+}
+
+// Update invalidates the object and cached lists once it has been updated
+func (c *Cache) Update(update Update) Update {
+	return func(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+		updated, err := update(ctx, object)
+		if !failed(err) {
+			c.Invalidate(object.ID)
+		}
+
+		return updated, err
+	}
+}
+
+// Delete invalidates the object and cached lists once it has been deleted
+func (c *Cache) Delete(del Delete) Delete {
+	return func(ctx context.Context, id string) error {
+		err := del(ctx, id)
+		if !failed(err) {
+			c.Invalidate(id)
+		}
+
+		return err
+	}
+}
+
+/*
+Invalidate removes every cached list, and the object with id for every tenant
+unless id is empty. Use it when objects are changed without going through the
+cache.
+*/
+func (c *Cache) Invalidate(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+
+	for key := range c.lists {
+		c.remove(key)
+	}
+
+	if id != "" {
+		for key := range c.objects[id] {
+			c.remove(key)
+		}
+	}
+}
+
+// Len returns how many entries are cached
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.recent.Len()
+}
+
+// key builds the cache key of an operation for the request's tenant
+func (c *Cache) key(ctx context.Context, operation string, value string) string {
+	tenant, _ := TenantFromContext(ctx)
+	return c.resourceType + "\x00" + tenant + "\x00" + operation + "\x00" + value
+}
+
+// fetch returns the cached result for key, or calls the backend once for every
+// concurrent miss and caches its result
+func (c *Cache) fetch(ctx context.Context, key string, id string, call func(*flight)) (*flight, error) {
+	c.mutex.Lock()
+
+	if element, cached := c.entries[key]; cached {
+		entry := element.Value.(*cacheEntry)
+		if c.options.TTL == 0 || c.options.Now().Before(entry.expires) {
+			c.recent.MoveToFront(element)
+			c.mutex.Unlock()
+			return &flight{object: entry.object, list: entry.list}, nil
+		}
+
+		c.remove(key)
+	}
+
+	if inFlight, exists := c.flights[key]; exists {
+		c.mutex.Unlock()
+
+		select {
+		case <-inFlight.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// the caller that made the call went away, which says nothing about
+		// this caller's request
+		if inFlight.cancelled && ctx.Err() == nil {
+			return c.fetch(ctx, key, id, call)
+		}
+
+		return inFlight, inFlight.err
+	}
+
+	current := &flight{done: make(chan struct{})}
+	c.flights[key] = current
+	generation := c.generation
+	c.mutex.Unlock()
+
+	// concurrent misses must be released even if the backend panics, in which
+	// case they fail while the panic carries on for this caller
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			current.err = jsh.ISE(fmt.Sprintf("Storage panicked: %v", recovered))
+		}
+
+		c.mutex.Lock()
+		delete(c.flights, key)
+		if current.err == nil && generation == c.generation {
+			c.add(&cacheEntry{
+				key:    key,
+				id:     id,
+				object: current.object,
+				list:   current.list,
+			})
+		}
+		c.mutex.Unlock()
+
+		close(current.done)
+
+		if recovered != nil {
+			panic(recovered)
+		}
+	}()
+
+	call(current)
+	if !failed(current.err) {
+		current.err = nil
+	}
+	current.cancelled = current.err != nil && ctx.Err() != nil && errors.Is(current.err, ctx.Err())
+
+	return current, current.err
+}
+
+// add caches an entry, evicting the least recently used entry if full
+func (c *Cache) add(entry *cacheEntry) {
+	if c.options.TTL > 0 {
+		entry.expires = c.options.Now().Add(c.options.TTL)
+	}
+
+	c.entries[entry.key] = c.recent.PushFront(entry)
+
+	if entry.id == "" {
+		c.lists[entry.key] = true
+	} else {
+		if c.objects[entry.id] == nil {
+			c.objects[entry.id] = map[string]bool{}
+		}
+		c.objects[entry.id][entry.key] = true
+	}
+
+	for c.recent.Len() > c.options.MaxEntries {
+		oldest := c.recent.Back().Value.(*cacheEntry)
+		c.remove(oldest.key)
+	}
+}
+
+// remove uncaches an entry
+func (c *Cache) remove(key string) {
+	element, cached := c.entries[key]
+	if !cached {
+		return
+	}
+
+	entry := element.Value.(*cacheEntry)
+	c.recent.Remove(element)
+	delete(c.entries, key)
+
+	if entry.id == "" {
+		delete(c.lists, key)
+		return
+	}
+
+	delete(c.objects[entry.id], key)
+	if len(c.objects[entry.id]) == 0 {
+		delete(c.objects, entry.id)
+	}
+}
+
+// funcCRUD is a CRUD implementation built from individual storage functions
+type funcCRUD struct {
+	save   Save
+	get    Get
+	list   List
+	update Update
+	delete Delete
+}
+
+func (c *funcCRUD) Save(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+	return c.save(ctx, object)
+}
+
+func (c *funcCRUD) Get(ctx context.Context, id string) (*jsh.Object, error) {
+	return c.get(ctx, id)
+}
+
+func (c *funcCRUD) List(ctx context.Context) (jsh.List, error) {
+	return c.list(ctx)
+}
+
+func (c *funcCRUD) Update(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+	return c.update(ctx, object)
+}
+
+func (c *funcCRUD) Delete(ctx context.Context, id string) error {
+	return c.delete(ctx, id)
+}
+
+// copyList copies every object of a list
+func copyList(objects jsh.List) jsh.List {
+	copied := make(jsh.List, 0, len(objects))
+	for _, object := range objects {
+		copied = append(copied, copyObject(object))
+	}
+
+	return copied
+}
+
+// failed checks whether storage returned an error, as storage can return a nil
+// *jsh.Error or empty jsh.ErrorList on success
+func failed(err error) bool {
+	switch typedErr := err.(type) {
+	case nil:
+		return false
+	case *jsh.Error:
+		return typedErr != nil
+	case jsh.ErrorList:
+		return len(typedErr) > 0
+	}
+
+	return true
+}
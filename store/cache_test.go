@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingStorage counts the calls made to a Memory store
+type countingStorage struct {
+	*Memory
+	gets  int32
+	lists int32
+	// release blocks Get until closed, if set
+	release chan struct{}
+}
+
+func (c *countingStorage) Get(ctx context.Context, id string) (*jsh.Object, error) {
+	atomic.AddInt32(&c.gets, 1)
+	if c.release != nil {
+		<-c.release
+	}
+
+	return c.Memory.Get(ctx, id)
+}
+
+func (c *countingStorage) List(ctx context.Context) (jsh.List, error) {
+	atomic.AddInt32(&c.lists, 1)
+	return c.Memory.List(ctx)
+}
+
+func TestCache(t *testing.T) {
+
+	Convey("Cache Tests", t, func() {
+
+		backend := &countingStorage{Memory: NewMemory("users")}
+		ctx := context.Background()
+
+		object, err := jsh.NewObject("", "users", map[string]string{"name": "bob"})
+		So(err, ShouldBeNil)
+		_, saveErr := backend.Save(ctx, object)
+		So(saveErr, ShouldBeNil)
+
+		now := time.Now()
+		cache := NewCache("users", CacheOptions{
+			TTL:        time.Minute,
+			MaxEntries: 2,
+			Now:        func() time.Time { return now },
+		})
+		storage := cache.CRUD(backend)
+
+		Convey("should read through on a miss only", func() {
+			first, err := storage.Get(ctx, "1")
+			So(err, ShouldBeNil)
+			first.Attributes[0] = '['
+
+			second, err := storage.Get(ctx, "1")
+			So(err, ShouldBeNil)
+			So(string(second.Attributes[0]), ShouldEqual, "{")
+			So(backend.gets, ShouldEqual, 1)
+		})
+
+		Convey("should not cache errors", func() {
+			_, err := storage.Get(ctx, "2")
+			So(err, ShouldNotBeNil)
+			_, err = storage.Get(ctx, "2")
+			So(err, ShouldNotBeNil)
+			So(backend.gets, ShouldEqual, 2)
+		})
+
+		Convey("should cache lists by normalized query", func() {
+			first := WithQuery(ctx, url.Values{"sort": {"name"}, "filter": {"b", "a"}})
+			second := WithQuery(ctx, url.Values{"filter": {"a", "b"}, "sort": {"name"}})
+
+			_, err := storage.List(first)
+			So(err, ShouldBeNil)
+			_, err = storage.List(second)
+			So(err, ShouldBeNil)
+			So(backend.lists, ShouldEqual, 1)
+
+			_, err = storage.List(ctx)
+			So(err, ShouldBeNil)
+			So(backend.lists, ShouldEqual, 2)
+		})
+
+		Convey("should cache each tenant separately", func() {
+			_, err := storage.List(WithTenant(ctx, "acme"))
+			So(err, ShouldBeNil)
+			_, err = storage.List(WithTenant(ctx, "globex"))
+			So(err, ShouldBeNil)
+			So(backend.lists, ShouldEqual, 2)
+		})
+
+		Convey("should invalidate on writes", func() {
+			storage.Get(ctx, "1")
+			storage.List(ctx)
+
+			update, err := jsh.NewObject("1", "users", map[string]string{"name": "alice"})
+			So(err, ShouldBeNil)
+			_, updateErr := storage.Update(ctx, update)
+			So(updateErr, ShouldBeNil)
+
+			updated, getErr := storage.Get(ctx, "1")
+			So(getErr, ShouldBeNil)
+			So(string(updated.Attributes), ShouldContainSubstring, "alice")
+			So(backend.gets, ShouldEqual, 2)
+
+			storage.List(ctx)
+			So(backend.lists, ShouldEqual, 2)
+
+			So(storage.Delete(ctx, "1"), ShouldBeNil)
+			_, getErr = storage.Get(ctx, "1")
+			So(getErr, ShouldNotBeNil)
+		})
+
+		Convey("should expire entries after the TTL", func() {
+			storage.Get(ctx, "1")
+			now = now.Add(time.Minute)
+			storage.Get(ctx, "1")
+			So(backend.gets, ShouldEqual, 2)
+		})
+
+		Convey("should evict the least recently used entries", func() {
+			storage.Get(ctx, "1")
+			storage.List(ctx)
+			storage.List(WithTenant(ctx, "acme"))
+			So(cache.Len(), ShouldEqual, 2)
+
+			storage.Get(ctx, "1")
+			So(backend.gets, ShouldEqual, 2)
+		})
+
+		Convey("should coalesce concurrent misses", func() {
+			backend.release = make(chan struct{})
+
+			waiting := sync.WaitGroup{}
+			results := make(chan *jsh.Object, 5)
+			for i := 0; i < 5; i++ {
+				waiting.Add(1)
+				go func() {
+					defer waiting.Done()
+					object, _ := storage.Get(ctx, "1")
+					results <- object
+				}()
+			}
+
+			time.Sleep(20 * time.Millisecond)
+			close(backend.release)
+			waiting.Wait()
+			close(results)
+
+			So(backend.gets, ShouldEqual, 1)
+			for object := range results {
+				So(object.ID, ShouldEqual, "1")
+			}
+		})
+
+		Convey("should retry concurrent misses when the first caller is cancelled", func() {
+			release := make(chan struct{})
+			calls := int32(0)
+			get := cache.Get(func(ctx context.Context, id string) (*jsh.Object, error) {
+				if atomic.AddInt32(&calls, 1) > 1 {
+					return backend.Get(ctx, id)
+				}
+
+				<-release
+				return nil, ctx.Err()
+			})
+
+			cancelled, cancel := context.WithCancel(ctx)
+			first := make(chan error, 1)
+			go func() {
+				_, err := get(cancelled, "1")
+				first <- err
+			}()
+			time.Sleep(20 * time.Millisecond)
+
+			waited := make(chan *jsh.Object, 1)
+			go func() {
+				object, _ := get(ctx, "1")
+				waited <- object
+			}()
+			time.Sleep(20 * time.Millisecond)
+
+			cancel()
+			close(release)
+			So(<-first, ShouldEqual, context.Canceled)
+
+			object := <-waited
+			So(object, ShouldNotBeNil)
+			So(object.ID, ShouldEqual, "1")
+		})
+
+		Convey("should release concurrent misses when storage panics", func() {
+			release := make(chan struct{})
+			get := cache.Get(func(ctx context.Context, id string) (*jsh.Object, error) {
+				<-release
+				panic("storage failure")
+			})
+
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() { panicked <- recover() }()
+				get(ctx, "1")
+			}()
+			time.Sleep(20 * time.Millisecond)
+
+			waited := make(chan error, 1)
+			go func() {
+				_, err := get(ctx, "1")
+				waited <- err
+			}()
+			time.Sleep(20 * time.Millisecond)
+
+			close(release)
+			So(<-panicked, ShouldEqual, "storage failure")
+			So(<-waited, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,288 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+/*
+Memory is a CRUD implementation that keeps objects of a single resource type in
+memory. It is useful for prototyping and testing, objects are copied on the way in
+and out so callers can't modify what is stored.
+
+Memory is also a Transactor. A transaction has the store to itself until it is
+committed or rolled back, other calls wait for it to finish or for their context
+to be done.
+*/
+type Memory struct {
+	ResourceType string
+	mutex        sync.RWMutex
+	objects      map[string]*jsh.Object
+	order        []string
+	nextID       int
+	// transaction is filled while a transaction, or a call outside of it, holds
+	// the store
+	transaction chan struct{}
+}
+
+// NewMemory creates an empty in-memory store for a resource type
+func NewMemory(resourceType string) *Memory {
+	return &Memory{
+		ResourceType: resourceType,
+		objects:      map[string]*jsh.Object{},
+		nextID:       1,
+		transaction:  make(chan struct{}, 1),
+	}
+}
+
+// Save stores a new object, generating a sequential ID if it doesn't have one
+func (m *Memory) Save(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+	release, err := m.exclusive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	saved := copyObject(object)
+	if saved.ID == "" {
+		for {
+			saved.ID = strconv.Itoa(m.nextID)
+			m.nextID++
+
+			if _, exists := m.objects[saved.ID]; !exists {
+				break
+			}
+		}
+	}
+
+	if _, exists := m.objects[saved.ID]; exists {
+		return nil, &jsh.Error{
+			Title:  "Conflict",
+			Detail: fmt.Sprintf("%s with id '%s' already exists", m.ResourceType, saved.ID),
+			Status: http.StatusConflict,
+		}
+	}
+
+	m.objects[saved.ID] = saved
+	m.order = append(m.order, saved.ID)
+
+	return copyObject(saved), nil
+}
+
+// Get retrieves an object by id
+func (m *Memory) Get(ctx context.Context, id string) (*jsh.Object, error) {
+	release, err := m.exclusive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	object, exists := m.objects[id]
+	if !exists {
+		return nil, jsh.NotFound(m.ResourceType, id)
+	}
+
+	return copyObject(object), nil
+}
+
+// List retrieves every object in the order they were saved
+func (m *Memory) List(ctx context.Context) (jsh.List, error) {
+	release, err := m.exclusive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	list := jsh.List{}
+	for _, id := range m.order {
+		list = append(list, copyObject(m.objects[id]))
+	}
+
+	return list, nil
+}
+
+// Update merges the attributes of object into the stored object
+func (m *Memory) Update(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+	release, err := m.exclusive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existing, exists := m.objects[object.ID]
+	if !exists {
+		return nil, jsh.NotFound(m.ResourceType, object.ID)
+	}
+
+	attributes, err := mergeAttributes(existing.Attributes, object.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := copyObject(existing)
+	updated.Attributes = attributes
+	m.objects[object.ID] = updated
+
+	return copyObject(updated), nil
+}
+
+// Delete removes an object by id
+func (m *Memory) Delete(ctx context.Context, id string) error {
+	release, err := m.exclusive(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.objects[id]; !exists {
+		return jsh.NotFound(m.ResourceType, id)
+	}
+
+	delete(m.objects, id)
+	for i, existing := range m.order {
+		if existing == id {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// Begin implements Transactor, waiting for any transaction in progress to finish
+func (m *Memory) Begin(ctx context.Context) (Tx, error) {
+	err := m.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	objects := make(map[string]*jsh.Object, len(m.objects))
+	for id, object := range m.objects {
+		objects[id] = object
+	}
+
+	return &memoryTx{
+		memory:  m,
+		objects: objects,
+		order:   append([]string{}, m.order...),
+		nextID:  m.nextID,
+	}, nil
+}
+
+// exclusive waits for any transaction in progress to finish unless ctx belongs to
+// it, returning the function to call once the storage call is done
+func (m *Memory) exclusive(ctx context.Context) (func(), error) {
+	tx, ok := TxFromContext(ctx)
+	if memTx, isMemory := tx.(*memoryTx); ok && isMemory && memTx.memory == m && !memTx.finished.Load() {
+		return func() {}, nil
+	}
+
+	err := m.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.release, nil
+}
+
+// acquire waits for the store to be free, giving up once ctx is done
+func (m *Memory) acquire(ctx context.Context) error {
+	select {
+	case m.transaction <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees the store for the next transaction or call
+func (m *Memory) release() {
+	<-m.transaction
+}
+
+// memoryTx is a transaction of a Memory store, holding a snapshot of the store to
+// roll back to. Objects are replaced rather than modified when updated, so the
+// snapshot can share them with the store.
+type memoryTx struct {
+	memory   *Memory
+	objects  map[string]*jsh.Object
+	order    []string
+	nextID   int
+	finished atomic.Bool
+}
+
+// Commit keeps the changes made during the transaction
+func (tx *memoryTx) Commit(ctx context.Context) error {
+	if !tx.finished.CompareAndSwap(false, true) {
+		return errTxFinished
+	}
+
+	tx.memory.release()
+	return nil
+}
+
+// Rollback restores the store to how it was when the transaction began
+func (tx *memoryTx) Rollback(ctx context.Context) error {
+	if !tx.finished.CompareAndSwap(false, true) {
+		return errTxFinished
+	}
+
+	tx.memory.mutex.Lock()
+	tx.memory.objects = tx.objects
+	tx.memory.order = tx.order
+	tx.memory.nextID = tx.nextID
+	tx.memory.mutex.Unlock()
+
+	tx.memory.release()
+	return nil
+}
+
+// copyObject copies an object, including its attributes
+func copyObject(object *jsh.Object) *jsh.Object {
+	copied := *object
+	copied.Attributes = append(json.RawMessage(nil), object.Attributes...)
+	return &copied
+}
+
+// mergeAttributes applies the top level attributes of a partial update
+func mergeAttributes(existing json.RawMessage, update json.RawMessage) (json.RawMessage, error) {
+	attributes := map[string]json.RawMessage{}
+
+	for _, raw := range []json.RawMessage{existing, update} {
+		if len(raw) == 0 {
+			continue
+		}
+
+		err := json.Unmarshal(raw, &attributes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(attributes)
+}
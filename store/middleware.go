@@ -0,0 +1,539 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-stdlogger"
+)
+
+// Storage operations seen by Middleware
+const (
+	CallSave   = "save"
+	CallGet    = "get"
+	CallList   = "list"
+	CallUpdate = "update"
+	CallDelete = "delete"
+	CallToMany = "tomany"
+)
+
+// ErrCircuitOpen is returned in place of calling storage while a CircuitBreaker
+// is open
+var ErrCircuitOpen = errors.New("store: circuit breaker is open")
+
+/*
+Call describes a single storage call to Middleware, regardless of which storage
+function is being called.
+*/
+type Call struct {
+	ResourceType string
+	// Operation is one of the Call* constants
+	Operation string
+	// ID is the object being fetched, updated, or deleted, or the parent of a
+	// ToMany call
+	ID string
+	// Object is the object being saved or updated
+	Object *jsh.Object
+}
+
+// Result is returned by a storage call, Object is set by Save, Get, and Update and
+// List by List and ToMany
+type Result struct {
+	Object *jsh.Object
+	List   jsh.List
+}
+
+// Handler performs a storage call
+type Handler func(ctx context.Context, call *Call) (*Result, error)
+
+/*
+Middleware decorates every storage call in the same manner, whichever storage
+function is being called:
+
+	func Timing(next store.Handler) store.Handler {
+		return func(ctx context.Context, call *store.Call) (*store.Result, error) {
+			start := time.Now()
+			defer func() { log.Printf("%s %s took %s", call.ResourceType, call.Operation, time.Since(start)) }()
+			return next(ctx, call)
+		}
+	}
+*/
+type Middleware func(next Handler) Handler
+
+/*
+Stack applies a chain of Middleware to the storage of a single resource type.
+Middleware are called in the order they are given, so the first is the outermost:
+
+	stack := store.NewStack("users", store.Logging(logger), store.Retry(store.RetryOptions{}), store.Timeout(time.Second))
+	resource := jshapi.NewCRUDResource("users", stack.CRUD(storage))
+
+Individual functions can be wrapped as well:
+
+	resource.ToMany("friend", stack.ToMany(friendStorage))
+*/
+type Stack struct {
+	ResourceType string
+	middleware   []Middleware
+}
+
+// NewStack creates a Stack for a resource type
+func NewStack(resourceType string, middleware ...Middleware) *Stack {
+	return &Stack{
+		ResourceType: resourceType,
+		middleware:   middleware,
+	}
+}
+
+// CRUD wraps every function of storage with the stack
+func (s *Stack) CRUD(storage CRUD) CRUD {
+	return &funcCRUD{
+		save:   s.Save(storage.Save),
+		get:    s.Get(storage.Get),
+		list:   s.List(storage.List),
+		update: s.Update(storage.Update),
+		delete: s.Delete(storage.Delete),
+	}
+}
+
+// Save wraps Save storage with the stack
+func (s *Stack) Save(save Save) Save {
+	handler := s.wrap(func(ctx context.Context, call *Call) (*Result, error) {
+		object, err := save(ctx, call.Object)
+		return &Result{Object: object}, err
+	})
+
+	return func(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+		result, err := handler(ctx, s.call(CallSave, object.ID, object))
+		return result.object(), err
+	}
+}
+
+// Get wraps Get storage with the stack
+func (s *Stack) Get(get Get) Get {
+	handler := s.wrap(func(ctx context.Context, call *Call) (*Result, error) {
+		object, err := get(ctx, call.ID)
+		return &Result{Object: object}, err
+	})
+
+	return func(ctx context.Context, id string) (*jsh.Object, error) {
+		result, err := handler(ctx, s.call(CallGet, id, nil))
+		return result.object(), err
+	}
+}
+
+// List wraps List storage with the stack
+func (s *Stack) List(list List) List {
+	handler := s.wrap(func(ctx context.Context, call *Call) (*Result, error) {
+		objects, err := list(ctx)
+		return &Result{List: objects}, err
+	})
+
+	return func(ctx context.Context) (jsh.List, error) {
+		result, err := handler(ctx, s.call(CallList, "", nil))
+		return result.list(), err
+	}
+}
+
+// Update wraps Update storage with the stack
+func (s *Stack) Update(update Update) Update {
+	handler := s.wrap(func(ctx context.Context, call *Call) (*Result, error) {
+		object, err := update(ctx, call.Object)
+		return &Result{Object: object}, err
+	})
+
+	return func(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+		result, err := handler(ctx, s.call(CallUpdate, object.ID, object))
+		return result.object(), err
+	}
+}
+
+// Delete wraps Delete storage with the stack
+func (s *Stack) Delete(del Delete) Delete {
+	handler := s.wrap(func(ctx context.Context, call *Call) (*Result, error) {
+		return &Result{}, del(ctx, call.ID)
+	})
+
+	return func(ctx context.Context, id string) error {
+		_, err := handler(ctx, s.call(CallDelete, id, nil))
+		return err
+	}
+}
+
+// ToMany wraps ToMany storage with the stack
+func (s *Stack) ToMany(toMany ToMany) ToMany {
+	handler := s.wrap(func(ctx context.Context, call *Call) (*Result, error) {
+		objects, err := toMany(ctx, call.ID)
+		return &Result{List: objects}, err
+	})
+
+	return func(ctx context.Context, id string) (jsh.List, error) {
+		result, err := handler(ctx, s.call(CallToMany, id, nil))
+		return result.list(), err
+	}
+}
+
+// wrap applies the stack's middleware to a handler
+func (s *Stack) wrap(handler Handler) Handler {
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		handler = s.middleware[i](handler)
+	}
+
+	return handler
+}
+
+// call describes a call to the stack's storage
+func (s *Stack) call(operation string, id string, object *jsh.Object) *Call {
+	return &Call{
+		ResourceType: s.ResourceType,
+		Operation:    operation,
+		ID:           id,
+		Object:       object,
+	}
+}
+
+// object returns the object of a result, which middleware may leave nil
+func (r *Result) object() *jsh.Object {
+	if r == nil {
+		return nil
+	}
+
+	return r.Object
+}
+
+// list returns the list of a result, which middleware may leave nil
+func (r *Result) list() jsh.List {
+	if r == nil {
+		return nil
+	}
+
+	return r.List
+}
+
+/*
+Timeout cancels the context of each storage call after timeout. Calls still
+running at that point return context.DeadlineExceeded straight away, even if
+storage ignores its context, in which case storage carries on in the background.
+Storage panics are carried over to the caller, unless it has already timed out.
+*/
+func Timeout(timeout time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (*Result, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			type returned struct {
+				result    *Result
+				err       error
+				recovered interface{}
+			}
+
+			done := make(chan returned, 1)
+			go func() {
+				called := returned{}
+				defer func() {
+					called.recovered = recover()
+					done <- called
+				}()
+
+				called.result, called.err = next(ctx, call)
+			}()
+
+			select {
+			case called := <-done:
+				if called.recovered != nil {
+					panic(called.recovered)
+				}
+
+				return called.result, called.err
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+}
+
+// RetryOptions configure Retry
+type RetryOptions struct {
+	// Attempts is the most times a call is made, defaults to 3
+	Attempts int
+	// Backoff is how long to wait after a failed attempt, numbered from 1, and
+	// defaults to DefaultBackoff
+	Backoff func(attempt int) time.Duration
+	// Retryable decides which errors are retried, defaults to Retryable
+	Retryable func(err error) bool
+	// Writes retries Save, Update, and Delete calls as well. Only reads are
+	// retried by default, as a write that failed or timed out may still have been
+	// made, or may still be running.
+	Writes bool
+}
+
+// DefaultBackoff waits 50ms after the first attempt, doubling after each attempt
+// up to 5s
+func DefaultBackoff(attempt int) time.Duration {
+	backoff := 50 * time.Millisecond
+	for i := 1; i < attempt && backoff < 5*time.Second; i++ {
+		backoff *= 2
+	}
+
+	if backoff > 5*time.Second {
+		return 5 * time.Second
+	}
+
+	return backoff
+}
+
+/*
+Retryable reports errors which are likely to succeed if retried: timeouts,
+errors with a `Temporary() bool` method returning true, and JSON API errors with
+a 502, 503, or 504 status.
+*/
+func Retryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+
+	var jshErr *jsh.Error
+	if errors.As(err, &jshErr) && jshErr != nil {
+		switch jshErr.Status {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+
+	return false
+}
+
+// Retry calls storage again when it returns a retryable error, waiting between
+// attempts, until it succeeds, the attempts run out, or the request is cancelled
+func Retry(options RetryOptions) Middleware {
+	if options.Attempts <= 0 {
+		options.Attempts = 3
+	}
+	if options.Backoff == nil {
+		options.Backoff = DefaultBackoff
+	}
+	if options.Retryable == nil {
+		options.Retryable = Retryable
+	}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (*Result, error) {
+			attempts := options.Attempts
+			if writes(call) && !options.Writes {
+				attempts = 1
+			}
+
+			for attempt := 1; ; attempt++ {
+				result, err := next(ctx, call)
+				if !failed(err) || attempt >= attempts || !options.Retryable(err) {
+					return result, err
+				}
+
+				wait := time.NewTimer(options.Backoff(attempt))
+				select {
+				case <-wait.C:
+				case <-ctx.Done():
+					wait.Stop()
+					return result, err
+				}
+			}
+		}
+	}
+}
+
+// writes checks whether a call changes storage
+func writes(call *Call) bool {
+	switch call.Operation {
+	case CallSave, CallUpdate, CallDelete:
+		return true
+	}
+
+	return false
+}
+
+// Logging logs every storage call, how long it took, and the error it returned if
+// it failed
+func Logging(logger std.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (*Result, error) {
+			start := time.Now()
+			result, err := next(ctx, call)
+			elapsed := time.Since(start)
+
+			if failed(err) {
+				logger.Printf("store: %s %s %q failed after %s: %s\n", call.ResourceType, call.Operation, call.ID, elapsed, err.Error())
+			} else {
+				logger.Printf("store: %s %s %q took %s\n", call.ResourceType, call.Operation, call.ID, elapsed)
+			}
+
+			return result, err
+		}
+	}
+}
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreakerOptions configure a CircuitBreaker
+type CircuitBreakerOptions struct {
+	// Failures is how many consecutive failures open the circuit, defaults to 5
+	Failures int
+	// Cooldown is how long the circuit stays open before a trial call is let
+	// through, defaults to 30s
+	Cooldown time.Duration
+	// Failure decides which errors count as failures of the backend, defaults to
+	// BackendFailure
+	Failure func(err error) bool
+	// Now defaults to time.Now
+	Now func() time.Time
+}
+
+/*
+CircuitBreaker stops calling storage that keeps failing. After Failures consecutive
+failures the circuit opens, and calls fail with ErrCircuitOpen without reaching
+storage. Once Cooldown has passed a single trial call is let through, closing the
+circuit if it succeeds or opening it again if it fails.
+
+Only errors accepted by Failure count, so that missing objects or invalid input
+don't open the circuit. Errors mapped to a 4XX status by an API can be excluded as
+well:
+
+	breaker := store.NewCircuitBreaker(store.CircuitBreakerOptions{
+		Failure: func(err error) bool {
+			return api.Errors.Map(err, "", "").StatusCode() >= 500
+		},
+	})
+
+A single CircuitBreaker can be shared by the storage of several resources that
+depend on the same backend.
+*/
+type CircuitBreaker struct {
+	options  CircuitBreakerOptions
+	mutex    sync.Mutex
+	state    string
+	failures int
+	opened   time.Time
+}
+
+// NewCircuitBreaker creates a closed CircuitBreaker
+func NewCircuitBreaker(options CircuitBreakerOptions) *CircuitBreaker {
+	if options.Failures <= 0 {
+		options.Failures = 5
+	}
+	if options.Cooldown <= 0 {
+		options.Cooldown = 30 * time.Second
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+	if options.Failure == nil {
+		options.Failure = BackendFailure
+	}
+
+	return &CircuitBreaker{
+		options: options,
+		state:   CircuitClosed,
+	}
+}
+
+// Middleware implements Middleware
+func (b *CircuitBreaker) Middleware(next Handler) Handler {
+	return func(ctx context.Context, call *Call) (*Result, error) {
+		if !b.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		result, err := next(ctx, call)
+		b.record(err)
+
+		return result, err
+	}
+}
+
+// State returns whether the circuit is closed, open, or half-open
+func (b *CircuitBreaker) State() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state
+}
+
+// allow checks whether a call may go through, moving an open circuit to half-open
+// once the cooldown has passed
+func (b *CircuitBreaker) allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if b.options.Now().Sub(b.opened) < b.options.Cooldown {
+			return false
+		}
+
+		b.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		// a trial call is already in progress
+		return false
+	}
+
+	return true
+}
+
+// record updates the circuit with the outcome of a call
+func (b *CircuitBreaker) record(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !failed(err) || !b.options.Failure(err) {
+		b.state = CircuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.options.Failures {
+		b.state = CircuitOpen
+		b.opened = b.options.Now()
+	}
+}
+
+/*
+BackendFailure reports errors that suggest the backend is failing. JSON API errors
+with a 4XX status, not found errors such as sql.ErrNoRows and fs.ErrNotExist, and
+cancelled requests are not the backend's fault.
+*/
+func BackendFailure(err error) bool {
+	if !failed(err) {
+		return false
+	}
+
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, fs.ErrNotExist) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	status := 0
+	switch typedErr := err.(type) {
+	case *jsh.Error:
+		status = typedErr.Status
+	case jsh.ErrorList:
+		status = typedErr.StatusCode()
+	}
+
+	return status < 400 || status >= 500
+}
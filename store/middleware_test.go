@@ -0,0 +1,172 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMiddleware(t *testing.T) {
+
+	Convey("Middleware Tests", t, func() {
+
+		ctx := context.Background()
+		unavailable := &jsh.Error{Title: "Unavailable", Status: http.StatusServiceUnavailable}
+
+		// flaky fails with err for the first failures calls
+		calls := 0
+		flaky := func(failures int, err error) Get {
+			return func(ctx context.Context, id string) (*jsh.Object, error) {
+				calls++
+				if calls <= failures {
+					return nil, err
+				}
+
+				return jsh.NewObject(id, "users", map[string]string{"name": "bob"})
+			}
+		}
+
+		noBackoff := func(int) time.Duration { return 0 }
+
+		Convey("should apply middleware in order to every function", func() {
+			order := []string{}
+			tag := func(name string) Middleware {
+				return func(next Handler) Handler {
+					return func(ctx context.Context, call *Call) (*Result, error) {
+						order = append(order, name+":"+call.Operation)
+						return next(ctx, call)
+					}
+				}
+			}
+
+			stack := NewStack("users", tag("outer"), tag("inner"))
+			storage := stack.CRUD(NewMemory("users"))
+
+			object, err := jsh.NewObject("", "users", map[string]string{"name": "bob"})
+			So(err, ShouldBeNil)
+
+			saved, saveErr := storage.Save(ctx, object)
+			So(saveErr, ShouldBeNil)
+			So(saved.ID, ShouldEqual, "1")
+
+			_, listErr := storage.List(ctx)
+			So(listErr, ShouldBeNil)
+
+			So(order, ShouldResemble, []string{"outer:save", "inner:save", "outer:list", "inner:list"})
+		})
+
+		Convey("should retry retryable errors", func() {
+			get := NewStack("users", Retry(RetryOptions{Backoff: noBackoff})).Get(flaky(2, unavailable))
+
+			object, err := get(ctx, "1")
+			So(err, ShouldBeNil)
+			So(object.ID, ShouldEqual, "1")
+			So(calls, ShouldEqual, 3)
+		})
+
+		Convey("should not retry other errors", func() {
+			get := NewStack("users", Retry(RetryOptions{Backoff: noBackoff})).Get(flaky(2, jsh.NotFound("users", "1")))
+
+			_, err := get(ctx, "1")
+			So(err, ShouldNotBeNil)
+			So(calls, ShouldEqual, 1)
+		})
+
+		Convey("should only retry reads by default", func() {
+			unavailableDelete := func(ctx context.Context, id string) error {
+				calls++
+				return unavailable
+			}
+
+			err := NewStack("users", Retry(RetryOptions{Backoff: noBackoff})).Delete(unavailableDelete)(ctx, "1")
+			So(err, ShouldEqual, unavailable)
+			So(calls, ShouldEqual, 1)
+
+			err = NewStack("users", Retry(RetryOptions{Backoff: noBackoff, Writes: true})).Delete(unavailableDelete)(ctx, "1")
+			So(err, ShouldEqual, unavailable)
+			So(calls, ShouldEqual, 4)
+		})
+
+		Convey("should time out slow calls", func() {
+			get := NewStack("users", Timeout(10*time.Millisecond)).Get(func(ctx context.Context, id string) (*jsh.Object, error) {
+				time.Sleep(time.Second)
+				return nil, nil
+			})
+
+			_, err := get(ctx, "1")
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+
+		Convey("should carry panics over to the caller", func() {
+			get := NewStack("users", Timeout(time.Second)).Get(func(ctx context.Context, id string) (*jsh.Object, error) {
+				panic("storage failure")
+			})
+
+			So(func() { get(ctx, "1") }, ShouldPanicWith, "storage failure")
+		})
+
+		Convey("should log calls", func() {
+			out := &bytes.Buffer{}
+			get := NewStack("users", Logging(log.New(out, "", 0))).Get(flaky(1, unavailable))
+
+			get(ctx, "1")
+			get(ctx, "1")
+			So(out.String(), ShouldContainSubstring, `store: users get "1" failed after`)
+			So(out.String(), ShouldContainSubstring, `store: users get "1" took`)
+		})
+
+		Convey("should open the circuit after repeated failures", func() {
+			now := time.Now()
+			breaker := NewCircuitBreaker(CircuitBreakerOptions{
+				Failures: 2,
+				Cooldown: time.Minute,
+				Now:      func() time.Time { return now },
+			})
+			get := NewStack("users", breaker.Middleware).Get(flaky(3, unavailable))
+
+			get(ctx, "1")
+			get(ctx, "1")
+			So(breaker.State(), ShouldEqual, CircuitOpen)
+
+			_, err := get(ctx, "1")
+			So(err, ShouldEqual, ErrCircuitOpen)
+			So(calls, ShouldEqual, 2)
+
+			Convey("should reopen if the trial call fails", func() {
+				now = now.Add(time.Minute)
+
+				_, err := get(ctx, "1")
+				So(err, ShouldEqual, unavailable)
+				So(breaker.State(), ShouldEqual, CircuitOpen)
+			})
+
+			Convey("should not count missing objects as failures", func() {
+				now = now.Add(time.Minute)
+				get := NewStack("users", breaker.Middleware).Get(func(ctx context.Context, id string) (*jsh.Object, error) {
+					return nil, sql.ErrNoRows
+				})
+
+				_, err := get(ctx, "1")
+				So(err, ShouldEqual, sql.ErrNoRows)
+				So(breaker.State(), ShouldEqual, CircuitClosed)
+			})
+
+			Convey("should close once a trial call succeeds", func() {
+				calls = 3
+				now = now.Add(time.Minute)
+
+				_, err := get(ctx, "1")
+				So(err, ShouldBeNil)
+				So(breaker.State(), ShouldEqual, CircuitClosed)
+			})
+		})
+	})
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"context"
+	"net/url"
+	"sort"
+)
+
+// queryKey stores the query parameters of a request within a context
+type queryKey struct{}
+
+// WithQuery stores the query parameters of a request within a context, allowing
+// List storage to filter, sort, and paginate
+func WithQuery(ctx context.Context, query url.Values) context.Context {
+	return context.WithValue(ctx, queryKey{}, query)
+}
+
+// QueryFromContext retrieves the query parameters of a request
+func QueryFromContext(ctx context.Context) (url.Values, bool) {
+	query, ok := ctx.Value(queryKey{}).(url.Values)
+	return query, ok
+}
+
+// NormalizeQuery encodes query parameters with both keys and values sorted, so that
+// equivalent queries are encoded identically
+func NormalizeQuery(query url.Values) string {
+	normalized := url.Values{}
+	for key, values := range query {
+		sorted := append([]string{}, values...)
+		sort.Strings(sorted)
+		normalized[key] = sorted
+	}
+
+	return normalized.Encode()
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+/*
+MarkDeleted builds SoftDelete storage from Update storage, setting the named
+attribute of an object to the time it was deleted:
+
+	resource.SoftDelete(jshapi.SoftDeletes{
+		Attribute: "deletedAt",
+		Delete:    store.MarkDeleted(storage.Update, "users", "deletedAt"),
+		Restore:   store.UnmarkDeleted(storage.Update, "users", "deletedAt"),
+	})
+*/
+func MarkDeleted(update Update, resourceType string, attribute string) SoftDelete {
+	return func(ctx context.Context, id string) error {
+		marked, err := SetAttribute(&jsh.Object{ID: id, Type: resourceType}, attribute, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		_, err = update(ctx, marked)
+		return err
+	}
+}
+
+// UnmarkDeleted builds Restore storage from Update storage, setting the named
+// attribute of an object back to null
+func UnmarkDeleted(update Update, resourceType string, attribute string) Restore {
+	return func(ctx context.Context, id string) (*jsh.Object, error) {
+		unmarked, err := SetAttribute(&jsh.Object{ID: id, Type: resourceType}, attribute, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return update(ctx, unmarked)
+	}
+}
+
+// IsDeleted checks whether the named attribute of object is set to anything but
+// null
+func IsDeleted(object *jsh.Object, attribute string) bool {
+	attributes := map[string]json.RawMessage{}
+	if object == nil || json.Unmarshal(object.Attributes, &attributes) != nil {
+		return false
+	}
+
+	marked, exists := attributes[attribute]
+	return exists && string(marked) != "null"
+}
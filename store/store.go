@@ -1,36 +1,86 @@
 // Package store is a collection of composable interfaces that are can be implemented
-// in order to build a storage driver
+// in order to build a storage driver.
+//
+// Storage functions can return any error. A jsh.ErrorType is sent to the client as
+// is, while other errors are translated into JSON API errors by the jshapi.API's
+// ErrorMapper.
 package store
 
 import (
+	"context"
+
 	"github.com/derekdowling/go-json-spec-handler"
-	"golang.org/x/net/context"
 )
 
 // CRUD implements all sub-storage functions
 type CRUD interface {
-	Save(ctx context.Context, object *jsh.Object) (*jsh.Object, jsh.ErrorType)
-	Get(ctx context.Context, id string) (*jsh.Object, jsh.ErrorType)
-	List(ctx context.Context) (jsh.List, jsh.ErrorType)
-	Update(ctx context.Context, object *jsh.Object) (*jsh.Object, jsh.ErrorType)
-	Delete(ctx context.Context, id string) jsh.ErrorType
+	Save(ctx context.Context, object *jsh.Object) (*jsh.Object, error)
+	Get(ctx context.Context, id string) (*jsh.Object, error)
+	List(ctx context.Context) (jsh.List, error)
+	Update(ctx context.Context, object *jsh.Object) (*jsh.Object, error)
+	Delete(ctx context.Context, id string) error
 }
 
 // Save a new resource to storage
-type Save func(ctx context.Context, object *jsh.Object) (*jsh.Object, jsh.ErrorType)
+type Save func(ctx context.Context, object *jsh.Object) (*jsh.Object, error)
 
 // Get a specific instance of a resource by id from storage
-type Get func(ctx context.Context, id string) (*jsh.Object, jsh.ErrorType)
+type Get func(ctx context.Context, id string) (*jsh.Object, error)
 
 // List all instances of a resource from storage
-type List func(ctx context.Context) (jsh.List, jsh.ErrorType)
+type List func(ctx context.Context) (jsh.List, error)
 
 // Update an existing object in storage
-type Update func(ctx context.Context, object *jsh.Object) (*jsh.Object, jsh.ErrorType)
+type Update func(ctx context.Context, object *jsh.Object) (*jsh.Object, error)
 
 // Delete an object from storage by id
-type Delete func(ctx context.Context, id string) jsh.ErrorType
+type Delete func(ctx context.Context, id string) error
+
+// SoftDelete marks an object as deleted by id, while keeping it in storage
+type SoftDelete func(ctx context.Context, id string) error
+
+// Restore undoes a SoftDelete, returning the restored object
+type Restore func(ctx context.Context, id string) (*jsh.Object, error)
 
 // ToMany retrieves a list of objects of a single resource type that are related to
 // the provided resource id
-type ToMany func(ctx context.Context, id string) (jsh.List, jsh.ErrorType)
+type ToMany func(ctx context.Context, id string) (jsh.List, error)
+
+// BatchToOne retrieves the related object of many parent resource ids at once,
+// keyed by parent id. Parents without a related object are left out.
+type BatchToOne func(ctx context.Context, ids []string) (map[string]*jsh.Object, error)
+
+// BatchToMany retrieves the related objects of many parent resource ids at once,
+// keyed by parent id
+type BatchToMany func(ctx context.Context, ids []string) (map[string]jsh.List, error)
+
+// BulkCRUD implements all bulk storage functions used for collection wide
+// mutations
+type BulkCRUD interface {
+	SaveAll(ctx context.Context, list jsh.List) (jsh.List, error)
+	UpdateAll(ctx context.Context, list jsh.List) (jsh.List, error)
+	DeleteAll(ctx context.Context, ids []string) error
+}
+
+// BulkSave saves a list of new resources to storage. Errors for individual
+// objects should be reported using their index within the list.
+type BulkSave func(ctx context.Context, list jsh.List) (jsh.List, error)
+
+// BulkUpdate updates a list of existing objects in storage
+type BulkUpdate func(ctx context.Context, list jsh.List) (jsh.List, error)
+
+// BulkDelete deletes a set of objects from storage by id
+type BulkDelete func(ctx context.Context, ids []string) error
+
+// Job performs long running storage work in the background, returning the
+// resulting object once complete
+type Job func(ctx context.Context) (*jsh.Object, error)
+
+// SaveAsync saves a new resource to storage. Returning a non-nil Job instead of
+// an object signals that the save is still pending and should be finished in the
+// background.
+type SaveAsync func(ctx context.Context, object *jsh.Object) (*jsh.Object, Job, error)
+
+// UpdateAsync updates an existing object in storage, optionally returning a
+// pending Job in the same manner as SaveAsync
+type UpdateAsync func(ctx context.Context, object *jsh.Object) (*jsh.Object, Job, error)
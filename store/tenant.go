@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/derekdowling/go-json-spec-handler"
+)
+
+// tenantKey stores the current tenant within a context
+type tenantKey struct{}
+
+// ErrNoTenant is returned by tenant scoped storage when no tenant has been resolved
+var ErrNoTenant = errors.New("no tenant resolved for request")
+
+// WithTenant stores the tenant a request is made on behalf of within a context
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext retrieves the tenant a request is made on behalf of
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+/*
+TenantScoped isolates tenants sharing a CRUD implementation. Every object stores
+the tenant it belongs to in the named attribute, which is set on Save and can't be
+changed by Update. Objects belonging to another tenant are reported as not found,
+and are left out of lists:
+
+	storage := store.TenantScoped(&UserStorage{}, "users", "tenant")
+	resource := jshapi.NewCRUDResource("users", storage)
+*/
+func TenantScoped(storage CRUD, resourceType string, attribute string) CRUD {
+	return &tenantScoped{
+		storage:      storage,
+		resourceType: resourceType,
+		attribute:    attribute,
+	}
+}
+
+// tenantScoped enforces tenant isolation around a CRUD implementation
+type tenantScoped struct {
+	storage      CRUD
+	resourceType string
+	attribute    string
+}
+
+// Save implements CRUD
+func (t *tenantScoped) Save(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, ErrNoTenant
+	}
+
+	scoped, err := SetAttribute(object, t.attribute, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.storage.Save(ctx, scoped)
+}
+
+// Get implements CRUD
+func (t *tenantScoped) Get(ctx context.Context, id string) (*jsh.Object, error) {
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, ErrNoTenant
+	}
+
+	object, err := t.storage.Get(ctx, id)
+	if failed(err) {
+		return nil, err
+	}
+
+	if !BelongsTo(object, t.attribute, tenant) {
+		return nil, jsh.NotFound(t.resourceType, id)
+	}
+
+	return object, nil
+}
+
+// List implements CRUD
+func (t *tenantScoped) List(ctx context.Context) (jsh.List, error) {
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, ErrNoTenant
+	}
+
+	list, err := t.storage.List(ctx)
+	if failed(err) {
+		return nil, err
+	}
+
+	scoped := jsh.List{}
+	for _, object := range list {
+		if BelongsTo(object, t.attribute, tenant) {
+			scoped = append(scoped, object)
+		}
+	}
+
+	return scoped, nil
+}
+
+// Update implements CRUD
+func (t *tenantScoped) Update(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+	_, err := t.Get(ctx, object.ID)
+	if failed(err) {
+		return nil, err
+	}
+
+	tenant, _ := TenantFromContext(ctx)
+	scoped, setErr := SetAttribute(object, t.attribute, tenant)
+	if setErr != nil {
+		return nil, setErr
+	}
+
+	return t.storage.Update(ctx, scoped)
+}
+
+// Delete implements CRUD
+func (t *tenantScoped) Delete(ctx context.Context, id string) error {
+	_, err := t.Get(ctx, id)
+	if failed(err) {
+		return err
+	}
+
+	return t.storage.Delete(ctx, id)
+}
+
+// BelongsTo checks whether the named attribute of object is set to tenant
+func BelongsTo(object *jsh.Object, attribute string, tenant string) bool {
+	attributes := map[string]json.RawMessage{}
+	if object == nil || json.Unmarshal(object.Attributes, &attributes) != nil {
+		return false
+	}
+
+	var owner string
+	if json.Unmarshal(attributes[attribute], &owner) != nil {
+		return false
+	}
+
+	return owner == tenant
+}
+
+// SetAttribute returns a copy of object with the named attribute set to value
+func SetAttribute(object *jsh.Object, attribute string, value interface{}) (*jsh.Object, error) {
+	attributes := map[string]json.RawMessage{}
+	if len(object.Attributes) > 0 {
+		err := json.Unmarshal(object.Attributes, &attributes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	attributes[attribute] = raw
+
+	updated := *object
+	updated.Attributes, err = json.Marshal(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTenantScoped(t *testing.T) {
+
+	Convey("TenantScoped Tests", t, func() {
+
+		memory := NewMemory("users")
+		storage := TenantScoped(memory, "users", "tenant")
+
+		acme := WithTenant(context.Background(), "acme")
+		globex := WithTenant(context.Background(), "globex")
+
+		object, err := jsh.NewObject("", "users", map[string]string{"name": "bob"})
+		So(err, ShouldBeNil)
+
+		saved, saveErr := storage.Save(acme, object)
+		So(saveErr, ShouldBeNil)
+		So(saved.ID, ShouldEqual, "1")
+		So(BelongsTo(saved, "tenant", "acme"), ShouldBeTrue)
+
+		Convey("should require a tenant", func() {
+			_, err := storage.Get(context.Background(), "1")
+			So(err, ShouldEqual, ErrNoTenant)
+		})
+
+		Convey("should isolate tenants", func() {
+			_, err := storage.Get(acme, "1")
+			So(err, ShouldBeNil)
+
+			_, err = storage.Get(globex, "1")
+			So(err.(*jsh.Error).Status, ShouldEqual, http.StatusNotFound)
+
+			list, err := storage.List(globex)
+			So(err, ShouldBeNil)
+			So(list, ShouldBeEmpty)
+
+			So(storage.Delete(globex, "1"), ShouldNotBeNil)
+		})
+
+		Convey("should keep objects within their tenant on update", func() {
+			update, err := SetAttribute(saved, "tenant", "globex")
+			So(err, ShouldBeNil)
+
+			updated, updateErr := storage.Update(acme, update)
+			So(updateErr, ShouldBeNil)
+			So(BelongsTo(updated, "tenant", "acme"), ShouldBeTrue)
+		})
+
+		Convey("should treat typed nil errors as success", func() {
+			typed := TenantScoped(&funcCRUD{
+				get: func(ctx context.Context, id string) (*jsh.Object, error) {
+					object, err := memory.Get(ctx, id)
+					if err != nil {
+						return nil, err
+					}
+
+					var getErr *jsh.Error
+					return object, getErr
+				},
+				delete: memory.Delete,
+			}, "users", "tenant")
+
+			_, err := typed.Get(acme, "1")
+			So(err, ShouldBeNil)
+			So(typed.Delete(acme, "1"), ShouldBeNil)
+		})
+
+		Convey("should report missing objects as not found", func() {
+			missing := TenantScoped(&funcCRUD{
+				get: func(ctx context.Context, id string) (*jsh.Object, error) {
+					return nil, nil
+				},
+			}, "users", "tenant")
+
+			_, err := missing.Get(acme, "1")
+			So(err.(*jsh.Error).Status, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func TestMemory(t *testing.T) {
+
+	Convey("Memory Tests", t, func() {
+
+		memory := NewMemory("users")
+		ctx := context.Background()
+
+		object, err := jsh.NewObject("", "users", map[string]string{"name": "bob", "role": "user"})
+		So(err, ShouldBeNil)
+
+		saved, saveErr := memory.Save(ctx, object)
+		So(saveErr, ShouldBeNil)
+
+		Convey("should merge updated attributes", func() {
+			update, err := jsh.NewObject(saved.ID, "users", map[string]string{"role": "admin"})
+			So(err, ShouldBeNil)
+
+			updated, updateErr := memory.Update(ctx, update)
+			So(updateErr, ShouldBeNil)
+			So(string(updated.Attributes), ShouldContainSubstring, `"name":"bob"`)
+			So(string(updated.Attributes), ShouldContainSubstring, `"role":"admin"`)
+		})
+
+		Convey("should not share stored objects", func() {
+			saved.Attributes[0] = '['
+
+			fetched, err := memory.Get(ctx, saved.ID)
+			So(err, ShouldBeNil)
+			So(string(fetched.Attributes[0]), ShouldEqual, "{")
+		})
+
+		Convey("should delete objects", func() {
+			So(memory.Delete(ctx, saved.ID), ShouldBeNil)
+
+			list, err := memory.List(ctx)
+			So(err, ShouldBeNil)
+			So(list, ShouldBeEmpty)
+		})
+	})
+}
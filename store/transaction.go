@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// errTxFinished is returned when committing or rolling back a transaction twice
+var errTxFinished = errors.New("store: transaction has already been committed or rolled back")
+
+/*
+Transactor is implemented by storage backends that support transactions. When
+a Transactor is registered with jshapi.NewTransactions, or as the storage of a CRUD
+resource, a transaction is begun for every mutating request and stored in the
+context passed to storage, so that every storage call made during the request can
+take part in it:
+
+	func (s *SQLStorage) Save(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+		tx, ok := store.TxFromContext(ctx)
+		if ok {
+			// use tx.(*SQLTx) instead of the connection pool
+		}
+		...
+	}
+*/
+type Transactor interface {
+	Begin(ctx context.Context) (Tx, error)
+}
+
+// Tx is a transaction begun by a Transactor, which is either committed or rolled
+// back exactly once
+type Tx interface {
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+// txKey stores the transaction of a request within a context
+type txKey struct{}
+
+// WithTx stores the transaction of a request within a context
+func WithTx(ctx context.Context, tx Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext retrieves the transaction of a request
+func TxFromContext(ctx context.Context) (Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(Tx)
+	return tx, ok
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryTransactions(t *testing.T) {
+
+	Convey("Memory Transaction Tests", t, func() {
+
+		memory := NewMemory("users")
+		ctx := context.Background()
+
+		object, err := jsh.NewObject("", "users", map[string]string{"name": "bob"})
+		So(err, ShouldBeNil)
+		_, saveErr := memory.Save(ctx, object)
+		So(saveErr, ShouldBeNil)
+
+		tx, beginErr := memory.Begin(ctx)
+		So(beginErr, ShouldBeNil)
+		txCtx := WithTx(ctx, tx)
+
+		_, saveErr = memory.Save(txCtx, object)
+		So(saveErr, ShouldBeNil)
+		So(memory.Delete(txCtx, "1"), ShouldBeNil)
+
+		Convey("should restore the store when rolled back", func() {
+			So(tx.Rollback(ctx), ShouldBeNil)
+
+			list, err := memory.List(ctx)
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 1)
+			So(list[0].ID, ShouldEqual, "1")
+
+			// IDs handed out during the transaction are handed out again
+			saved, saveErr := memory.Save(ctx, object)
+			So(saveErr, ShouldBeNil)
+			So(saved.ID, ShouldEqual, "2")
+
+			So(tx.Commit(ctx), ShouldNotBeNil)
+		})
+
+		Convey("should keep changes when committed", func() {
+			So(tx.Commit(ctx), ShouldBeNil)
+
+			list, err := memory.List(ctx)
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 1)
+			So(list[0].ID, ShouldEqual, "2")
+		})
+
+		Convey("should make other calls wait for the transaction", func() {
+			listed := make(chan int)
+			go func() {
+				list, _ := memory.List(ctx)
+				listed <- len(list)
+			}()
+
+			select {
+			case <-listed:
+				So("listed during the transaction", ShouldBeEmpty)
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			So(tx.Rollback(ctx), ShouldBeNil)
+			So(<-listed, ShouldEqual, 1)
+		})
+
+		Convey("should stop waiting once the context is done", func() {
+			waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+
+			_, beginErr := memory.Begin(waitCtx)
+			So(errors.Is(beginErr, context.DeadlineExceeded), ShouldBeTrue)
+
+			_, listErr := memory.List(waitCtx)
+			So(errors.Is(listErr, context.DeadlineExceeded), ShouldBeTrue)
+
+			So(tx.Rollback(ctx), ShouldBeNil)
+		})
+	})
+}
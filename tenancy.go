@@ -0,0 +1,250 @@
+package jshapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/jsh-api/store"
+)
+
+/*
+TenantResolver finds the tenant a request is made on behalf of, returning an empty
+string if it can't. The request returned is used for the rest of the request's
+lifecycle, allowing resolvers to strip a tenant from the URL.
+*/
+type TenantResolver func(r *http.Request) (string, *http.Request)
+
+// TenantFromSubdomain resolves "acme" for requests to "acme.<domain>"
+func TenantFromSubdomain(domain string) TenantResolver {
+	suffix := "." + strings.TrimPrefix(domain, ".")
+
+	return func(r *http.Request) (string, *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+
+		if !strings.HasSuffix(host, suffix) {
+			return "", r
+		}
+
+		return strings.TrimSuffix(host, suffix), r
+	}
+}
+
+// TenantFromHeader resolves the tenant sent via the named header, i.e. "X-Tenant"
+func TenantFromHeader(header string) TenantResolver {
+	return func(r *http.Request) (string, *http.Request) {
+		return r.Header.Get(header), r
+	}
+}
+
+/*
+TenantFromPathPrefix resolves "acme" for requests to "/acme/<resources>", routing
+the rest of the path as usual. As the tenant is stripped from the path by the
+resolver, the request's Route is resolved by the resource rather than the API.
+*/
+func TenantFromPathPrefix(r *http.Request) (string, *http.Request) {
+	trimmed := strings.TrimPrefix(r.URL.Path, "/")
+
+	parts := strings.SplitN(trimmed, "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", r
+	}
+
+	stripped := new(http.Request)
+	*stripped = *r
+	stripped.URL = new(url.URL)
+	*stripped.URL = *r.URL
+	stripped.URL.Path = "/" + parts[1]
+	stripped.URL.RawPath = ""
+
+	return parts[0], stripped
+}
+
+// TenantFromPrincipal resolves the tenant from the named claim of the principal
+// identified by an Authenticator, which must run first
+func TenantFromPrincipal(claim string) TenantResolver {
+	return func(r *http.Request) (string, *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			return "", r
+		}
+
+		return principal.Claims[claim], r
+	}
+}
+
+/*
+Tenancy is a middleware that resolves the tenant of every request, and stores it in
+the request's context for storage to use via store.TenantFromContext(ctx):
+
+	tenancy := jshapi.NewTenancy(
+		jshapi.TenantFromSubdomain("example.com"),
+		jshapi.TenantFromHeader("X-Tenant"),
+	)
+	api.Use(tenancy.Middleware)
+
+Resolvers are tried in order. Requests without a tenant are rejected with a 400,
+unless Optional is set.
+*/
+type Tenancy struct {
+	Resolvers []TenantResolver
+	Optional  bool
+}
+
+// NewTenancy creates a Tenancy middleware
+func NewTenancy(resolvers ...TenantResolver) *Tenancy {
+	return &Tenancy{
+		Resolvers: resolvers,
+	}
+}
+
+// Middleware can be registered via API.Use() or Resource.Use()
+func (t *Tenancy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, resolver := range t.Resolvers {
+			tenant, resolved := resolver(r)
+			if tenant != "" {
+				next.ServeHTTP(w, resolved.WithContext(store.WithTenant(resolved.Context(), tenant)))
+				return
+			}
+		}
+
+		if t.Optional {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		SendHandler(r.Context(), w, r, MissingTenant())
+	})
+}
+
+// MissingTenant creates a 400 error for requests without a tenant
+func MissingTenant() *jsh.Error {
+	return &jsh.Error{
+		Title:  "Missing Tenant",
+		Detail: "Unable to determine which tenant the request is for",
+		Status: http.StatusBadRequest,
+	}
+}
+
+/*
+TenantScoped declares that every object of the resource belongs to the tenant
+stored in the named attribute. Objects of another tenant are reported as not found
+by every operation and are left out of lists. New objects are assigned to the
+current tenant, and objects can't be moved to another tenant. The resource's Get
+storage is used to check which tenant an object belongs to before it is modified,
+so it must be registered.
+
+Use store.TenantScoped to enforce the same isolation within storage itself.
+*/
+func (res *Resource) TenantScoped(attribute string) {
+	res.tenantAttribute = attribute
+}
+
+// tenancy checks that the target of an operation belongs to the request's tenant
+func (res *Resource) tenancy(ctx context.Context, operation Operation, target Target) jsh.ErrorType {
+	if res.tenantAttribute == "" {
+		return nil
+	}
+
+	tenant, ok := store.TenantFromContext(ctx)
+	if !ok {
+		return MissingTenant()
+	}
+
+	// fetched objects are checked by tenantRead, and lists by tenantList
+	if operation == OpGet || operation == OpList {
+		return nil
+	}
+
+	if target.ID != "" && operation != OpPost {
+		if res.get == nil {
+			return jsh.ISE(fmt.Sprintf("Tenant scoped resource '%s' requires Get storage", res.Type))
+		}
+
+		storageCtx, observe := res.instrument(ctx, target.ID)
+		existing, err := res.get(storageCtx, target.ID)
+		observe(err)
+		sendableErr := res.storageError(err, target.ID)
+		if sendableErr != nil {
+			return sendableErr
+		}
+
+		if !store.BelongsTo(existing, res.tenantAttribute, tenant) {
+			return jsh.NotFound(res.Type, target.ID)
+		}
+	}
+
+	if target.Object != nil {
+		return res.tenantWrite(tenant, operation, target.Object)
+	}
+
+	return nil
+}
+
+// tenantWrite assigns new objects to the request's tenant, and rejects objects
+// written for another tenant
+func (res *Resource) tenantWrite(tenant string, operation Operation, object *jsh.Object) jsh.ErrorType {
+	attributes, _ := objectAttributes(object)
+
+	if _, set := attributes[res.tenantAttribute]; set {
+		if store.BelongsTo(object, res.tenantAttribute, tenant) {
+			return nil
+		}
+
+		tenantErr := Forbidden("Objects can only be written for the current tenant")
+		tenantErr.Source.Pointer = fmt.Sprintf("/data/attributes/%s", res.tenantAttribute)
+		return tenantErr
+	}
+
+	if operation != OpPost {
+		return nil
+	}
+
+	scoped, err := store.SetAttribute(object, res.tenantAttribute, tenant)
+	if err != nil {
+		return jsh.ISE(fmt.Sprintf("Error assigning tenant: %s", err.Error()))
+	}
+
+	object.Attributes = scoped.Attributes
+	return nil
+}
+
+// tenantRead reports objects of another tenant as not found
+func (res *Resource) tenantRead(ctx context.Context, object *jsh.Object) jsh.ErrorType {
+	if res.tenantAttribute == "" || object == nil || object.Type != res.Type {
+		return nil
+	}
+
+	tenant, _ := store.TenantFromContext(ctx)
+	if !store.BelongsTo(object, res.tenantAttribute, tenant) {
+		return jsh.NotFound(res.Type, object.ID)
+	}
+
+	return nil
+}
+
+// tenantList filters a list down to the objects of the request's tenant
+func (res *Resource) tenantList(ctx context.Context, list jsh.List) jsh.List {
+	if res.tenantAttribute == "" {
+		return list
+	}
+
+	tenant, _ := store.TenantFromContext(ctx)
+
+	scoped := jsh.List{}
+	for _, object := range list {
+		if object.Type != res.Type || store.BelongsTo(object, res.tenantAttribute, tenant) {
+			scoped = append(scoped, object)
+		}
+	}
+
+	return scoped
+}
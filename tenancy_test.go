@@ -0,0 +1,121 @@
+package jshapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	"github.com/derekdowling/jsh-api/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTenancy(t *testing.T) {
+
+	storage := store.NewMemory(testResourceType)
+	ctx := store.WithTenant(context.Background(), "acme")
+	storage.Save(ctx, sampleObject("1", testResourceType, map[string]string{"tenant": "acme"}))
+	storage.Save(ctx, sampleObject("2", testResourceType, map[string]string{"tenant": "globex"}))
+
+	resource := NewCRUDResource(testResourceType, storage)
+	resource.TenantScoped("tenant")
+
+	api := New("")
+	api.Use(NewTenancy(TenantFromHeader("X-Tenant")).Middleware)
+	api.Add(resource)
+
+	server := httptest.NewServer(api)
+	baseURL := server.URL
+
+	do := func(request *http.Request, mode jsh.DocumentMode) (*jsh.Document, *http.Response) {
+		request.Header.Set("X-Tenant", "acme")
+
+		doc, resp, err := jsc.Do(request, mode)
+		So(err, ShouldBeNil)
+		return doc, resp
+	}
+
+	Convey("Tenancy Tests", t, func() {
+
+		Convey("should reject requests without a tenant", func() {
+			_, resp, err := jsc.Fetch(baseURL, testResourceType, "1")
+
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("should hide other tenants' objects", func() {
+			request, err := jsc.FetchRequest(baseURL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			_, resp := do(request, jsh.ObjectMode)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			request, err = jsc.FetchRequest(baseURL, testResourceType, "2")
+			So(err, ShouldBeNil)
+			_, resp = do(request, jsh.ObjectMode)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			request, err = jsc.ListRequest(baseURL, testResourceType)
+			So(err, ShouldBeNil)
+			doc, _ := do(request, jsh.ListMode)
+			So(len(doc.Data), ShouldEqual, 1)
+		})
+
+		Convey("should not modify other tenants' objects", func() {
+			request, err := jsc.PatchRequest(baseURL, sampleObject("2", testResourceType, testObjAttrs))
+			So(err, ShouldBeNil)
+			_, resp := do(request, jsh.ObjectMode)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			request, err = jsc.DeleteRequest(baseURL, testResourceType, "2")
+			So(err, ShouldBeNil)
+			request.Header.Set("X-Tenant", "acme")
+			resp, err = http.DefaultClient.Do(request)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("should assign new objects to the current tenant", func() {
+			request, err := jsc.PostRequest(baseURL, sampleObject("", testResourceType, testObjAttrs))
+			So(err, ShouldBeNil)
+			doc, resp := do(request, jsh.ObjectMode)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+			So(store.BelongsTo(doc.Data[0], "tenant", "acme"), ShouldBeTrue)
+
+			request, err = jsc.PostRequest(baseURL, sampleObject("", testResourceType, map[string]string{"tenant": "globex"}))
+			So(err, ShouldBeNil)
+			_, resp = do(request, jsh.ObjectMode)
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestTenantResolvers(t *testing.T) {
+
+	Convey("Tenant Resolver Tests", t, func() {
+
+		request := httptest.NewRequest("GET", "http://acme.example.com/acme/bars/1", nil)
+
+		Convey("->TenantFromSubdomain()", func() {
+			tenant, _ := TenantFromSubdomain("example.com")(request)
+			So(tenant, ShouldEqual, "acme")
+		})
+
+		Convey("->TenantFromPathPrefix()", func() {
+			tenant, stripped := TenantFromPathPrefix(request)
+			So(tenant, ShouldEqual, "acme")
+			So(stripped.URL.Path, ShouldEqual, "/bars/1")
+			So(request.URL.Path, ShouldEqual, "/acme/bars/1")
+		})
+
+		Convey("->TenantFromPrincipal()", func() {
+			principal := &Principal{ID: "1", Claims: map[string]string{"org": "acme"}}
+			request = request.WithContext(WithPrincipal(request.Context(), principal))
+
+			tenant, _ := TenantFromPrincipal("org")(request)
+			So(tenant, ShouldEqual, "acme")
+		})
+	})
+}
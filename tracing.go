@@ -0,0 +1,344 @@
+package jshapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tracerKey      = contextKey("tracer")
+	spanContextKey = contextKey("spanContext")
+
+	// TraceparentHeader is the W3C Trace Context header used to propagate traces
+	TraceparentHeader = "traceparent"
+)
+
+// Span attributes set by jshapi
+const (
+	AttrResourceType = "jshapi.resource_type"
+	AttrResourceID   = "jshapi.resource_id"
+	AttrOperation    = "jshapi.operation"
+	AttrRelationship = "jshapi.relationship"
+	AttrHTTPMethod   = "http.method"
+	AttrHTTPStatus   = "http.status_code"
+)
+
+/*
+Tracer starts spans, allowing jshapi to be traced by any tracing library with a
+thin adapter. Start returns a context carrying the new span, which Start should
+use to find the span's parent via SpanContextFromContext.
+*/
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single timed unit of work within a trace
+type Span interface {
+	SetAttribute(key string, value string)
+	RecordError(err error)
+	End()
+}
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats the span context as a W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value
+func ParseTraceparent(header string) (SpanContext, bool) {
+	sc := SpanContext{}
+
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+
+	// version 00 has exactly four fields, later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) {
+		return sc, false
+	}
+
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || len(parts[3]) != 2 {
+		return sc, false
+	}
+	sc.Sampled = flags&1 == 1
+
+	return sc, sc.IsValid()
+}
+
+// decodeHex decodes a lowercase hex string of exactly len(dst) bytes into dst
+func decodeHex(dst []byte, src string) bool {
+	if len(src) != hex.EncodedLen(len(dst)) || strings.ToLower(src) != src {
+		return false
+	}
+
+	_, err := hex.Decode(dst, []byte(src))
+	return err == nil
+}
+
+// ContextWithSpanContext stores the current span context, for Tracer
+// implementations and for propagating remote parents
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey, sc)
+}
+
+// SpanContextFromContext returns the current span context, if there is one
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey).(SpanContext)
+	return sc, ok
+}
+
+// InjectTraceparent sets the traceparent header of an outgoing request to the
+// current span, continuing the trace downstream
+func InjectTraceparent(ctx context.Context, header http.Header) {
+	sc, ok := SpanContextFromContext(ctx)
+	if ok && sc.IsValid() {
+		header.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+/*
+Tracing is a middleware that traces every request, continuing traces started by
+callers via the traceparent header:
+
+	recorder := jshapi.NewSpanRecorder()
+	api.Use(jshapi.NewTracing(recorder).Middleware)
+
+Requests are traced by a "jshapi.request" span, with child spans for each storage
+call ("jshapi.storage"), include resolution ("jshapi.include"), and serialization
+("jshapi.serialize"). Spans carry the resource type, ID, and operation.
+*/
+type Tracing struct {
+	Tracer Tracer
+}
+
+// NewTracing creates a Tracing middleware
+func NewTracing(tracer Tracer) *Tracing {
+	return &Tracing{Tracer: tracer}
+}
+
+// Middleware can be registered via API.Use() or Resource.Use()
+func (t *Tracing) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), tracerKey, t.Tracer)
+
+		if remote, ok := ParseTraceparent(r.Header.Get(TraceparentHeader)); ok {
+			ctx = ContextWithSpanContext(ctx, remote)
+		}
+
+		ctx, span := t.Tracer.Start(ctx, "jshapi.request")
+		defer span.End()
+
+		span.SetAttribute(AttrHTTPMethod, r.Method)
+		if route, routed := RouteFromContext(ctx); routed {
+			span.SetAttribute(AttrResourceType, route.ResourceType)
+			span.SetAttribute(AttrOperation, string(route.Operation))
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		status := recorder.Status()
+		span.SetAttribute(AttrHTTPStatus, strconv.Itoa(status))
+		if status >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("%d %s", status, http.StatusText(status)))
+		}
+	})
+}
+
+/*
+StartSpan starts a child span of the current span, using the Tracer of the Tracing
+middleware handling the request. If the request isn't traced, the span returned
+does nothing.
+*/
+func StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	tracer, ok := ctx.Value(tracerKey).(Tracer)
+	if !ok {
+		return ctx, noopSpan{}
+	}
+
+	return tracer.Start(ctx, name)
+}
+
+// startSpan starts a span labelled with the resource type and operation
+func (res *Resource) startSpan(ctx context.Context, name string, id string) (context.Context, Span) {
+	ctx, span := StartSpan(ctx, name)
+	span.SetAttribute(AttrResourceType, res.Type)
+
+	if route, routed := RouteFromContext(ctx); routed {
+		span.SetAttribute(AttrOperation, string(route.Operation))
+	}
+
+	if id != "" {
+		span.SetAttribute(AttrResourceID, id)
+	}
+
+	return ctx, span
+}
+
+/*
+instrument traces and times a storage call for object id, returning the context to
+call storage with and a function to call with the storage error once it returns.
+*/
+func (res *Resource) instrument(ctx context.Context, id string) (context.Context, func(error)) {
+	observe := res.observeStorage(ctx)
+	storageCtx, span := res.startSpan(ctx, "jshapi.storage", id)
+
+	return storageCtx, func(err error) {
+		observe(err)
+
+		if normalizeError(err) != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}
+}
+
+// noopSpan is used when a request isn't traced
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value string) {}
+func (noopSpan) RecordError(err error)                 {}
+func (noopSpan) End()                                  {}
+
+/*
+SpanRecorder is a Tracer that keeps spans in memory, for use in tests:
+
+	recorder := jshapi.NewSpanRecorder()
+	api.Use(jshapi.NewTracing(recorder).Middleware)
+	...
+	spans := recorder.Spans()
+*/
+type SpanRecorder struct {
+	mutex sync.Mutex
+	spans []*RecordedSpan
+}
+
+// RecordedSpan is a span kept by a SpanRecorder
+type RecordedSpan struct {
+	Name       string
+	Context    SpanContext
+	Parent     SpanContext
+	Attributes map[string]string
+	Errors     []error
+	StartTime  time.Time
+	EndTime    time.Time
+
+	recorder *SpanRecorder
+}
+
+// NewSpanRecorder creates an empty SpanRecorder
+func NewSpanRecorder() *SpanRecorder {
+	return &SpanRecorder{}
+}
+
+// Start implements Tracer
+func (s *SpanRecorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &RecordedSpan{
+		Name:       name,
+		Attributes: map[string]string{},
+		StartTime:  time.Now(),
+		recorder:   s,
+	}
+
+	parent, hasParent := SpanContextFromContext(ctx)
+	if hasParent && parent.IsValid() {
+		span.Parent = parent
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = true
+	}
+	rand.Read(span.Context.SpanID[:])
+
+	return ContextWithSpanContext(ctx, span.Context), span
+}
+
+// Spans returns the spans that have ended, in the order they ended
+func (s *SpanRecorder) Spans() []*RecordedSpan {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	spans := make([]*RecordedSpan, len(s.spans))
+	copy(spans, s.spans)
+	return spans
+}
+
+// Named returns the ended spans called name
+func (s *SpanRecorder) Named(name string) []*RecordedSpan {
+	named := []*RecordedSpan{}
+	for _, span := range s.Spans() {
+		if span.Name == name {
+			named = append(named, span)
+		}
+	}
+
+	return named
+}
+
+// Reset forgets all recorded spans
+func (s *SpanRecorder) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.spans = nil
+}
+
+// SetAttribute implements Span
+func (r *RecordedSpan) SetAttribute(key string, value string) {
+	r.recorder.mutex.Lock()
+	defer r.recorder.mutex.Unlock()
+
+	r.Attributes[key] = value
+}
+
+// RecordError implements Span
+func (r *RecordedSpan) RecordError(err error) {
+	r.recorder.mutex.Lock()
+	defer r.recorder.mutex.Unlock()
+
+	r.Errors = append(r.Errors, err)
+}
+
+// End implements Span
+func (r *RecordedSpan) End() {
+	r.recorder.mutex.Lock()
+	defer r.recorder.mutex.Unlock()
+
+	if !r.EndTime.IsZero() {
+		return
+	}
+
+	r.EndTime = time.Now()
+	r.recorder.spans = append(r.recorder.spans, r)
+}
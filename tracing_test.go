@@ -0,0 +1,108 @@
+package jshapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	"github.com/derekdowling/jsh-api/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTracing(t *testing.T) {
+
+	Convey("Tracing Tests", t, func() {
+
+		recorder := NewSpanRecorder()
+
+		api := New("")
+		api.Use(NewTracing(recorder).Middleware)
+		api.Add(NewMockResource(testResourceType, 1, testObjAttrs))
+
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		Convey("should trace requests, storage, and serialization", func() {
+			_, resp, err := jsc.Fetch(server.URL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			requests := recorder.Named("jshapi.request")
+			So(requests, ShouldHaveLength, 1)
+			request := requests[0]
+			So(request.Attributes[AttrResourceType], ShouldEqual, testResourceType)
+			So(request.Attributes[AttrOperation], ShouldEqual, string(OpGet))
+			So(request.Attributes[AttrHTTPStatus], ShouldEqual, "200")
+
+			storage := recorder.Named("jshapi.storage")
+			So(storage, ShouldHaveLength, 1)
+			So(storage[0].Attributes[AttrResourceID], ShouldEqual, "1")
+			So(storage[0].Parent, ShouldResemble, request.Context)
+			So(storage[0].Context.TraceID, ShouldResemble, request.Context.TraceID)
+
+			serialize := recorder.Named("jshapi.serialize")
+			So(serialize, ShouldHaveLength, 1)
+			So(serialize[0].Parent, ShouldResemble, request.Context)
+		})
+
+		Convey("should continue traces from the traceparent header", func() {
+			traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+			request, err := jsc.FetchRequest(server.URL, testResourceType, "1")
+			So(err, ShouldBeNil)
+			request.Header.Set(TraceparentHeader, traceparent)
+
+			_, _, err = jsc.Do(request, jsh.ObjectMode)
+			So(err, ShouldBeNil)
+
+			span := recorder.Named("jshapi.request")[0]
+			So(span.Parent.Traceparent(), ShouldEqual, traceparent)
+			So(span.Context.TraceID, ShouldResemble, span.Parent.TraceID)
+		})
+
+		Convey("should record storage errors", func() {
+			api.Add(NewCRUDResource("foos", store.NewMemory("foos")))
+
+			_, resp, err := jsc.Fetch(server.URL, "foos", "1")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+
+			storage := recorder.Named("jshapi.storage")
+			So(storage, ShouldHaveLength, 1)
+			So(storage[0].Errors, ShouldHaveLength, 1)
+			So(recorder.Named("jshapi.serialize"), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestTraceparent(t *testing.T) {
+
+	Convey("Traceparent Tests", t, func() {
+
+		Convey("should round trip valid headers", func() {
+			header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+
+			sc, ok := ParseTraceparent(header)
+			So(ok, ShouldBeTrue)
+			So(sc.Sampled, ShouldBeFalse)
+			So(sc.Traceparent(), ShouldEqual, header)
+		})
+
+		Convey("should reject invalid headers", func() {
+			invalid := []string{
+				"",
+				"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+				"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+				"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			}
+
+			for _, header := range invalid {
+				_, ok := ParseTraceparent(header)
+				So(ok, ShouldBeFalse)
+			}
+		})
+	})
+}
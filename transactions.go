@@ -0,0 +1,179 @@
+package jshapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/jsh-api/store"
+)
+
+/*
+Transactions makes each mutating request a unit of work. A transaction is begun
+before the request is handled and stored in its context, where storage can find
+it with store.TxFromContext. The response is held back until the request is done,
+then the transaction is committed if it succeeded, or rolled back if an error was
+sent or the handler panicked:
+
+	storage := store.NewMemory("users")
+
+	api := jshapi.New("")
+	api.Use(jshapi.NewTransactions(storage).Middleware)
+	api.Add(jshapi.NewCRUDResource("users", storage))
+
+Resources registered with CRUD use Transactions on their own when their storage is
+a Transactor, so the middleware is only needed to make requests a unit of work
+across other storage.
+
+If committing fails, an error is sent in place of the held back response. Requests
+reading objects, and asynchronous jobs finished in the background, aren't part of a
+transaction. Changes are published to audit sinks and ChangeListeners once the
+transaction is committed, and not at all if it is rolled back.
+*/
+type Transactions struct {
+	Transactor store.Transactor
+}
+
+// NewTransactions creates Transactions for a storage backend
+func NewTransactions(transactor store.Transactor) *Transactions {
+	return &Transactions{Transactor: transactor}
+}
+
+// Middleware implements the standard net/http middleware signature
+func (t *Transactions) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		// requests already within a transaction, from an outer Transactions,
+		// are left to it
+		_, inTx := store.TxFromContext(ctx)
+		if inTx || !mutates(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tx, err := t.Transactor.Begin(ctx)
+		if normalizeError(err) != nil {
+			SendHandler(ctx, w, r, txError("beginning", err))
+			return
+		}
+
+		effects := &sideEffects{}
+		buffered := newBufferedResponse()
+		committed := false
+		defer func() {
+			if !committed {
+				tx.Rollback(ctx)
+				effects.finish()
+			}
+		}()
+
+		txCtx := context.WithValue(store.WithTx(ctx, tx), effectsKey, effects)
+		next.ServeHTTP(buffered, r.WithContext(txCtx))
+
+		if buffered.status >= 400 {
+			buffered.flush(w)
+			return
+		}
+
+		committed = true
+		err = tx.Commit(ctx)
+		if normalizeError(err) != nil {
+			effects.finish()
+			SendHandler(ctx, w, r, txError("committing", err))
+			return
+		}
+
+		flushErr := effects.flush()
+		if flushErr != nil {
+			SendHandler(ctx, w, r, flushErr)
+			return
+		}
+
+		buffered.flush(w)
+	})
+}
+
+// withoutTransaction detaches ctx from the transaction of its request, and from
+// the side effects held back until it commits
+func withoutTransaction(ctx context.Context) context.Context {
+	ctx = store.WithTx(ctx, nil)
+	return context.WithValue(ctx, effectsKey, nil)
+}
+
+// mutates checks whether a request changes objects
+func mutates(r *http.Request) bool {
+	route, routed := RouteFromContext(r.Context())
+	if !routed {
+		return r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+	}
+
+	switch route.Operation {
+	case OpPost, OpPatch, OpDelete:
+		return true
+	}
+
+	return route.Operation.Kind() == "action"
+}
+
+// txError sends errors from the Transactor as is if they are already JSON API
+// errors, or as an ISE otherwise
+func txError(action string, err error) jsh.ErrorType {
+	if errorType, isType := normalizeError(err).(jsh.ErrorType); isType {
+		return errorType
+	}
+
+	return jsh.ISE(fmt.Sprintf("Error %s transaction: %s", action, err.Error()))
+}
+
+// bufferedResponse holds back a response until it is flushed
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   *bytes.Buffer
+}
+
+// newBufferedResponse creates an empty bufferedResponse
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{
+		header: http.Header{},
+		body:   &bytes.Buffer{},
+	}
+}
+
+// Header implements http.ResponseWriter
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+// WriteHeader implements http.ResponseWriter
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// Write implements http.ResponseWriter
+func (b *bufferedResponse) Write(content []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+
+	return b.body.Write(content)
+}
+
+// flush sends the held back response
+func (b *bufferedResponse) flush(w http.ResponseWriter) {
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
@@ -0,0 +1,159 @@
+package jshapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	"github.com/derekdowling/jsh-api/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// failingTransactor begins transactions that fail to commit
+type failingTransactor struct{}
+
+func (failingTransactor) Begin(ctx context.Context) (store.Tx, error) {
+	return failingTx{}, nil
+}
+
+// failingTx fails to commit
+type failingTx struct{}
+
+func (failingTx) Commit(ctx context.Context) error {
+	return errors.New("database went away")
+}
+
+func (failingTx) Rollback(ctx context.Context) error {
+	return nil
+}
+
+// recordingListener keeps every change event published to it
+type recordingListener struct {
+	mutex    sync.Mutex
+	received []*ChangeEvent
+}
+
+func (l *recordingListener) Publish(ctx context.Context, event *ChangeEvent) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.received = append(l.received, event)
+	return nil
+}
+
+func (l *recordingListener) events() []*ChangeEvent {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.received
+}
+
+func TestTransactions(t *testing.T) {
+
+	Convey("Transactions Tests", t, func() {
+
+		storage := store.NewMemory(testResourceType)
+		sink := NewMemoryAuditSink()
+
+		resource := NewResource(testResourceType)
+		resource.Post(storage.Save)
+		resource.Audit(sink)
+
+		failures := NewResource("failures")
+		failures.Post(func(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+			object.Type = testResourceType
+			storage.Save(ctx, object)
+			return nil, errors.New("failed after saving")
+		})
+
+		panics := NewResource("panics")
+		panics.Post(func(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+			object.Type = testResourceType
+			storage.Save(ctx, object)
+			panic(http.ErrAbortHandler)
+		})
+
+		api := New("")
+		transactions := NewTransactions(storage)
+		api.Use(transactions.Middleware)
+		api.Add(resource)
+		api.Add(failures)
+		api.Add(panics)
+
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		stored := func() jsh.List {
+			list, err := storage.List(context.Background())
+			So(err, ShouldBeNil)
+			return list
+		}
+
+		audited := func() []*AuditEntry {
+			entries, err := sink.Entries(context.Background())
+			So(err, ShouldBeNil)
+			return entries
+		}
+
+		Convey("should commit successful requests", func() {
+			_, resp, err := jsc.Post(server.URL, sampleObject("", testResourceType, testObjAttrs))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, jsh.ContentType)
+
+			So(stored(), ShouldHaveLength, 1)
+			So(audited(), ShouldHaveLength, 1)
+		})
+
+		Convey("should roll back requests that send an error", func() {
+			_, resp, err := jsc.Post(server.URL, sampleObject("", "failures", testObjAttrs))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+
+			So(stored(), ShouldBeEmpty)
+		})
+
+		Convey("should roll back requests that panic", func() {
+			_, _, err := jsc.Post(server.URL, sampleObject("", "panics", testObjAttrs))
+			So(err, ShouldNotBeNil)
+
+			So(stored(), ShouldBeEmpty)
+		})
+
+		Convey("should not publish changes that fail to commit", func() {
+			transactions.Transactor = failingTransactor{}
+
+			events := &recordingListener{}
+			resource.Listen(events)
+
+			_, resp, err := jsc.Post(server.URL, sampleObject("", testResourceType, testObjAttrs))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+
+			So(audited(), ShouldBeEmpty)
+			So(events.events(), ShouldBeEmpty)
+		})
+
+		Convey("should be used by CRUD resources with transactional storage", func() {
+			inTx := false
+			crud := NewCRUDResource("foos", store.NewMemory("foos"))
+			crud.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					_, inTx = store.TxFromContext(r.Context())
+					next.ServeHTTP(w, r)
+				})
+			})
+			api.Add(crud)
+
+			_, resp, err := jsc.Post(server.URL, sampleObject("", "foos", testObjAttrs))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+			So(inTx, ShouldBeTrue)
+		})
+	})
+}
@@ -1,7 +1,9 @@
 package jshapi
 
 import (
+	"bytes"
 	"log"
+	"net/http"
 
 	"github.com/derekdowling/go-json-spec-handler"
 )
@@ -40,3 +42,51 @@ func sampleObject(id string, resourceType string, sampleObject interface{}) *jsh
 
 	return object
 }
+
+// responseRecorder captures the status of a response, and its body if body is set,
+// while still writing it to the client
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+	body   *bytes.Buffer
+}
+
+// WriteHeader implements http.ResponseWriter
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	if r.body != nil {
+		r.body.Write(b)
+	}
+
+	written, err := r.ResponseWriter.Write(b)
+	r.bytes += written
+	return written, err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying ResponseWriter,
+// so that streaming responses can be flushed
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Status returns the status code sent, defaulting to 200 like net/http
+func (r *responseRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+
+	return r.status
+}
@@ -0,0 +1,916 @@
+package jshapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-stdlogger"
+	"github.com/derekdowling/jsh-api/store"
+)
+
+const (
+	// WebhookType is the resource type of webhook subscriptions
+	WebhookType = "webhooks"
+	// WebhookDeliveryType is the resource type of webhook deliveries
+	WebhookDeliveryType = "deliveries"
+
+	// WebhookSignatureHeader carries the signature of a delivery, see SignWebhook
+	WebhookSignatureHeader = "X-Jshapi-Signature"
+	// WebhookEventHeader carries the type of change a delivery is for
+	WebhookEventHeader = "X-Jshapi-Event"
+	// WebhookDeliveryHeader carries the ID of a delivery, which is the same for
+	// every attempt, allowing receivers to ignore duplicates
+	WebhookDeliveryHeader = "X-Jshapi-Delivery"
+
+	// DefaultWebhookAttempts is how many times a delivery is attempted
+	DefaultWebhookAttempts = 8
+	// DefaultWebhookTimeout bounds each delivery attempt
+	DefaultWebhookTimeout = 10 * time.Second
+	// DefaultWebhookPollInterval is how often queued deliveries are checked
+	DefaultWebhookPollInterval = time.Second
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// errSubscriptionGone fails the deliveries of a subscription that was deleted
+var errSubscriptionGone = errors.New("subscription has been deleted")
+
+/*
+WebhookSubscription asks for changes to be delivered to URL. Empty ResourceTypes or
+Events match every resource type or event. Secret signs deliveries, and is never
+sent back to clients.
+
+Principal and Tenant record who created the subscription, and can't be set by
+clients. Only the changes they are allowed to see are delivered.
+*/
+type WebhookSubscription struct {
+	URL           string     `json:"url"`
+	Secret        string     `json:"secret,omitempty"`
+	ResourceTypes []string   `json:"resourceTypes,omitempty"`
+	Events        []string   `json:"events,omitempty"`
+	Principal     *Principal `json:"principal,omitempty"`
+	Tenant        string     `json:"tenant,omitempty"`
+}
+
+// matches checks whether the subscription wants event
+func (s *WebhookSubscription) matches(event *ChangeEvent) bool {
+	return matchesAny(s.ResourceTypes, event.ResourceType) && matchesAny(s.Events, event.Type)
+}
+
+// owner acts on behalf of whoever created the subscription, rather than the caller
+// making the change
+func (s *WebhookSubscription) owner(ctx context.Context) context.Context {
+	return store.WithTenant(WithPrincipal(ctx, s.Principal), s.Tenant)
+}
+
+// decodeSubscription decodes the attributes of a subscription
+func decodeSubscription(object *jsh.Object) (*WebhookSubscription, error) {
+	subscription := &WebhookSubscription{}
+	err := json.Unmarshal(object.Attributes, subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	return subscription, nil
+}
+
+// matchesAny checks whether value is in values, or values is empty
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookAttempt records a single attempt to deliver a webhook
+type WebhookAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// WebhookDelivery is a change queued for delivery to a subscription
+type WebhookDelivery struct {
+	ID             string           `json:"id,omitempty"`
+	SubscriptionID string           `json:"subscriptionId"`
+	Event          string           `json:"event"`
+	ResourceType   string           `json:"resourceType"`
+	ResourceID     string           `json:"resourceId"`
+	Payload        json.RawMessage  `json:"payload"`
+	Status         string           `json:"status"`
+	Attempts       []WebhookAttempt `json:"attempts"`
+	Created        time.Time        `json:"created"`
+	NextAttempt    time.Time        `json:"nextAttempt"`
+}
+
+// copy returns a copy of the delivery that shares nothing with the original
+func (d *WebhookDelivery) copy() *WebhookDelivery {
+	copied := *d
+	copied.Payload = append(json.RawMessage{}, d.Payload...)
+	copied.Attempts = append([]WebhookAttempt{}, d.Attempts...)
+	return &copied
+}
+
+/*
+WebhookQueue stores deliveries until they succeed or run out of attempts. Queues
+must keep their own copies of deliveries.
+*/
+type WebhookQueue interface {
+	// Push queues a new delivery
+	Push(ctx context.Context, delivery *WebhookDelivery) error
+	// Due returns the pending deliveries whose next attempt is at or before now
+	Due(ctx context.Context, now time.Time) ([]*WebhookDelivery, error)
+	// Update stores the outcome of an attempt
+	Update(ctx context.Context, delivery *WebhookDelivery) error
+	// List returns the deliveries of a subscription, oldest first
+	List(ctx context.Context, subscriptionID string) ([]*WebhookDelivery, error)
+}
+
+// MemoryWebhookQueue keeps deliveries in memory, losing them on restart
+type MemoryWebhookQueue struct {
+	mutex      sync.RWMutex
+	deliveries map[string]*WebhookDelivery
+	order      []string
+}
+
+// NewMemoryWebhookQueue creates an empty MemoryWebhookQueue
+func NewMemoryWebhookQueue() *MemoryWebhookQueue {
+	return &MemoryWebhookQueue{
+		deliveries: map[string]*WebhookDelivery{},
+	}
+}
+
+// Push implements WebhookQueue
+func (m *MemoryWebhookQueue) Push(ctx context.Context, delivery *WebhookDelivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.deliveries[delivery.ID]; exists {
+		return fmt.Errorf("delivery '%s' already queued", delivery.ID)
+	}
+
+	m.deliveries[delivery.ID] = delivery.copy()
+	m.order = append(m.order, delivery.ID)
+	return nil
+}
+
+// Due implements WebhookQueue
+func (m *MemoryWebhookQueue) Due(ctx context.Context, now time.Time) ([]*WebhookDelivery, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	due := []*WebhookDelivery{}
+	for _, id := range m.order {
+		delivery := m.deliveries[id]
+		if delivery.Status == DeliveryPending && !delivery.NextAttempt.After(now) {
+			due = append(due, delivery.copy())
+		}
+	}
+
+	return due, nil
+}
+
+// Update implements WebhookQueue
+func (m *MemoryWebhookQueue) Update(ctx context.Context, delivery *WebhookDelivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.deliveries[delivery.ID]; !exists {
+		return fmt.Errorf("delivery '%s' is not queued", delivery.ID)
+	}
+
+	m.deliveries[delivery.ID] = delivery.copy()
+	return nil
+}
+
+// List implements WebhookQueue
+func (m *MemoryWebhookQueue) List(ctx context.Context, subscriptionID string) ([]*WebhookDelivery, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	deliveries := []*WebhookDelivery{}
+	for _, id := range m.order {
+		delivery := m.deliveries[id]
+		if delivery.SubscriptionID == subscriptionID {
+			deliveries = append(deliveries, delivery.copy())
+		}
+	}
+
+	return deliveries, nil
+}
+
+/*
+FileWebhookQueue is a MemoryWebhookQueue that saves every change to a JSON file,
+so that queued deliveries survive restarts. The file is replaced atomically.
+*/
+type FileWebhookQueue struct {
+	*MemoryWebhookQueue
+	path string
+	// saving serializes writes to the file
+	saving sync.Mutex
+}
+
+// NewFileWebhookQueue loads the deliveries saved at path, if there are any
+func NewFileWebhookQueue(path string) (*FileWebhookQueue, error) {
+	queue := &FileWebhookQueue{
+		MemoryWebhookQueue: NewMemoryWebhookQueue(),
+		path:               path,
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return queue, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []*WebhookDelivery{}
+	err = json.Unmarshal(contents, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, delivery := range deliveries {
+		queue.deliveries[delivery.ID] = delivery
+		queue.order = append(queue.order, delivery.ID)
+	}
+
+	return queue, nil
+}
+
+// Push implements WebhookQueue
+func (f *FileWebhookQueue) Push(ctx context.Context, delivery *WebhookDelivery) error {
+	err := f.MemoryWebhookQueue.Push(ctx, delivery)
+	if err != nil {
+		return err
+	}
+
+	return f.save()
+}
+
+// Update implements WebhookQueue
+func (f *FileWebhookQueue) Update(ctx context.Context, delivery *WebhookDelivery) error {
+	err := f.MemoryWebhookQueue.Update(ctx, delivery)
+	if err != nil {
+		return err
+	}
+
+	return f.save()
+}
+
+// save writes every delivery to a temporary file, then moves it into place
+func (f *FileWebhookQueue) save() error {
+	f.saving.Lock()
+	defer f.saving.Unlock()
+
+	f.mutex.RLock()
+	deliveries := make([]*WebhookDelivery, 0, len(f.order))
+	for _, id := range f.order {
+		deliveries = append(deliveries, f.deliveries[id])
+	}
+	contents, err := json.Marshal(deliveries)
+	f.mutex.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(contents)
+	if err == nil {
+		err = temp.Sync()
+	}
+	closeErr := temp.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return os.Rename(temp.Name(), f.path)
+}
+
+/*
+Webhooks delivers changes to the URLs of registered subscriptions. Subscriptions
+are managed through their own resource, and Webhooks listens for changes like any
+other ChangeListener:
+
+	webhooks := jshapi.NewWebhooks(store.NewMemory(jshapi.WebhookType), queue)
+
+	subscriptions := webhooks.Resource()
+	subscriptions.Authorize(adminsOnly)
+	api.Add(subscriptions)
+	api.Listen(webhooks)
+
+	webhooks.Start()
+	defer webhooks.Close()
+
+Every change matching a subscription is queued as a delivery when it is made, then
+POSTed to the subscription's URL as a JSON API document, signed with the
+subscription's secret, see SignWebhook. Failed deliveries are retried with
+exponential backoff until MaxAttempts is reached, and every attempt is recorded
+and served at GET /webhooks/:id/deliveries.
+
+Changes are delivered as the subscription's creator would see them. Objects they
+may not get, or that belong to another tenant, aren't delivered, and objects are
+delivered without the attributes they aren't allowed to read.
+*/
+type Webhooks struct {
+	// Subscriptions stores the subscriptions managed by Resource()
+	Subscriptions store.CRUD
+	// Queue stores deliveries until they succeed or fail
+	Queue WebhookQueue
+	// Client sends deliveries, see NewWebhookClient
+	Client *http.Client
+	// MaxAttempts is how many times a delivery is attempted before it fails
+	MaxAttempts int
+	// Backoff is how long to wait after the given number of failed attempts
+	Backoff func(attempts int) time.Duration
+	// PollInterval is how often queued deliveries are checked once started
+	PollInterval time.Duration
+	// Now defaults to time.Now
+	Now func() time.Time
+	// Logger reports deliveries that couldn't be recorded once started
+	Logger std.Logger
+
+	notify  chan struct{}
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// NewWebhooks creates Webhooks with the default attempts and backoff
+func NewWebhooks(subscriptions store.CRUD, queue WebhookQueue) *Webhooks {
+	return &Webhooks{
+		Subscriptions: subscriptions,
+		Queue:         queue,
+		Client:        NewWebhookClient(DefaultWebhookTimeout),
+		MaxAttempts:   DefaultWebhookAttempts,
+		Backoff:       WebhookBackoff,
+		PollInterval:  DefaultWebhookPollInterval,
+		Now:           time.Now,
+		Logger:        log.New(os.Stderr, "jshapi: ", log.LstdFlags),
+		notify:        make(chan struct{}, 1),
+	}
+}
+
+/*
+NewWebhookClient creates the client deliveries are sent with by default. It refuses
+to connect to loopback, private, link-local, and other non-public addresses, even
+after a redirect, so that subscriptions can't be used to reach internal services.
+*/
+func NewWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || !publicIP(ip) {
+				return fmt.Errorf("refusing to deliver to non-public address %s", host)
+			}
+
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// publicIP checks whether ip is routable on the public internet
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// WebhookBackoff waits 1s after the first failed attempt, doubling with every
+// attempt after that up to an hour
+func WebhookBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+
+	delay := time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+
+	if delay > time.Hour {
+		return time.Hour
+	}
+
+	return delay
+}
+
+/*
+Resource creates the subscriptions resource, serving CRUD at /webhooks and each
+subscription's deliveries at /webhooks/:id/deliveries. Secrets are write-only, and
+subscriptions of another tenant are reported as not found.
+
+As subscribers receive the changes of every resource their creator may see, every
+request is forbidden until a policy is attached via Authorize.
+*/
+func (w *Webhooks) Resource() *Resource {
+	storage := &webhookStorage{CRUD: w.Subscriptions}
+	resource := NewCRUDResource(WebhookType, storage)
+
+	resource.Authorize(func(ctx context.Context, principal *Principal, operation Operation, target Target) error {
+		return Forbidden("Webhook subscriptions require an authorization policy")
+	})
+
+	nobody := func(ctx context.Context, principal *Principal, object *jsh.Object) bool {
+		return false
+	}
+	resource.AttributePolicy("secret", AttributePolicy{Read: nobody})
+	resource.AttributePolicy("principal", AttributePolicy{Read: nobody, Write: nobody})
+	resource.AttributePolicy("tenant", AttributePolicy{Write: nobody})
+
+	resource.ToMany(WebhookDeliveryType, func(ctx context.Context, id string) (jsh.List, error) {
+		_, err := storage.Get(ctx, id)
+		if normalizeError(err) != nil {
+			return nil, err
+		}
+
+		return w.deliveries(ctx, id)
+	})
+
+	return resource
+}
+
+// deliveries lists the deliveries of a subscription
+func (w *Webhooks) deliveries(ctx context.Context, id string) (jsh.List, error) {
+	deliveries, err := w.Queue.List(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	list := jsh.List{}
+	for _, delivery := range deliveries {
+		attributes := delivery.copy()
+		attributes.ID = ""
+
+		object, objectErr := jsh.NewObject(delivery.ID, WebhookDeliveryType, attributes)
+		if objectErr != nil {
+			return nil, objectErr
+		}
+
+		list = append(list, object)
+	}
+
+	return list, nil
+}
+
+// Publish implements ChangeListener, queueing a delivery for every subscription
+// matching event whose creator may see the change
+func (w *Webhooks) Publish(ctx context.Context, event *ChangeEvent) error {
+	subscriptions, err := w.Subscriptions.List(ctx)
+	if normalizeError(err) != nil {
+		return err
+	}
+
+	queued := false
+	for _, object := range subscriptions {
+		subscription, decodeErr := decodeSubscription(object)
+		if decodeErr != nil || !subscription.matches(event) {
+			continue
+		}
+
+		data, visible := event.resource.visible(subscription.owner(ctx), event)
+		if !visible {
+			continue
+		}
+
+		delivery, err := w.newDelivery(object.ID, event, data)
+		if err != nil {
+			return err
+		}
+
+		err = w.Queue.Push(ctx, delivery)
+		if err != nil {
+			return err
+		}
+		queued = true
+	}
+
+	if queued {
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// newDelivery creates the delivery of event to a subscription, data being the
+// event's payload as the subscription's creator may see it
+func (w *Webhooks) newDelivery(subscriptionID string, event *ChangeEvent, data interface{}) (*WebhookDelivery, error) {
+	id := randomID()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"data": data,
+		"meta": map[string]string{
+			"event":    event.Type,
+			"delivery": id,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := w.Now()
+	return &WebhookDelivery{
+		ID:             id,
+		SubscriptionID: subscriptionID,
+		Event:          event.Type,
+		ResourceType:   event.ResourceType,
+		ResourceID:     event.ResourceID,
+		Payload:        payload,
+		Status:         DeliveryPending,
+		Attempts:       []WebhookAttempt{},
+		Created:        now,
+		NextAttempt:    now,
+	}, nil
+}
+
+// Deliver attempts every delivery that is due, returning how many were attempted.
+// A delivery whose outcome can't be recorded doesn't keep the others from being
+// attempted.
+func (w *Webhooks) Deliver(ctx context.Context) (int, error) {
+	due, err := w.Queue.Due(ctx, w.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	updateErrs := []error{}
+	for _, delivery := range due {
+		w.attempt(ctx, delivery)
+
+		err = w.Queue.Update(ctx, delivery)
+		if err != nil {
+			updateErrs = append(updateErrs, fmt.Errorf("recording delivery '%s': %w", delivery.ID, err))
+		}
+	}
+
+	return len(due), errors.Join(updateErrs...)
+}
+
+// attempt sends a delivery once, recording the outcome on it
+func (w *Webhooks) attempt(ctx context.Context, delivery *WebhookDelivery) {
+	now := w.Now()
+	statusCode, err := w.send(ctx, now, delivery)
+
+	attempt := WebhookAttempt{Time: now, StatusCode: statusCode}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	switch {
+	case err == nil:
+		delivery.Status = DeliveryDelivered
+	case errors.Is(err, errSubscriptionGone), len(delivery.Attempts) >= w.MaxAttempts:
+		delivery.Status = DeliveryFailed
+	default:
+		delivery.NextAttempt = now.Add(w.Backoff(len(delivery.Attempts)))
+	}
+}
+
+// send POSTs a delivery to its subscription's URL
+func (w *Webhooks) send(ctx context.Context, now time.Time, delivery *WebhookDelivery) (int, error) {
+	object, err := w.Subscriptions.Get(ctx, delivery.SubscriptionID)
+	if isNotFound(err) || (normalizeError(err) == nil && object == nil) {
+		return 0, errSubscriptionGone
+	}
+	if normalizeError(err) != nil {
+		return 0, fmt.Errorf("subscription unavailable: %s", err.Error())
+	}
+
+	subscription, err := decodeSubscription(object)
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := http.NewRequest("POST", subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request = request.WithContext(ctx)
+
+	request.Header.Set("Content-Type", jsh.ContentType)
+	request.Header.Set(WebhookEventHeader, delivery.Event)
+	request.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(subscription.Secret, now, delivery.Payload))
+
+	response, err := w.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("receiver responded with %d", response.StatusCode)
+	}
+
+	return response.StatusCode, nil
+}
+
+// Start delivers queued webhooks in the background until Close is called
+func (w *Webhooks) Start() {
+	w.done = make(chan struct{})
+	w.stopped.Add(1)
+
+	go func() {
+		defer w.stopped.Done()
+
+		ticker := time.NewTicker(w.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+			case <-w.notify:
+			}
+
+			_, err := w.Deliver(context.Background())
+			if err != nil {
+				w.Logger.Printf("Error delivering webhooks: %s\n", err.Error())
+			}
+		}
+	}()
+}
+
+// Close stops delivering webhooks, waiting for the current attempts to finish
+func (w *Webhooks) Close() {
+	if w.done != nil {
+		close(w.done)
+		w.stopped.Wait()
+	}
+}
+
+/*
+SignWebhook signs a delivery body, returning the value of the signature header:
+
+	t=<unix timestamp>,v1=<hex HMAC-SHA256 of "<timestamp>.<body>">
+
+Receivers should check the signature with VerifyWebhook.
+*/
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", unix, webhookMAC(secret, unix, body))
+}
+
+// VerifyWebhook checks a signature header sent by SignWebhook, rejecting
+// signatures older than tolerance to prevent deliveries from being replayed
+func VerifyWebhook(secret string, header string, body []byte, now time.Time, tolerance time.Duration) bool {
+	values := map[string]string{}
+	for _, part := range strings.Split(header, ",") {
+		pair := strings.SplitN(part, "=", 2)
+		if len(pair) == 2 {
+			values[pair[0]] = pair[1]
+		}
+	}
+
+	unix, err := strconv.ParseInt(values["t"], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := now.Sub(time.Unix(unix, 0))
+	if age > tolerance || age < -tolerance {
+		return false
+	}
+
+	expected := webhookMAC(secret, values["t"], body)
+	return hmac.Equal([]byte(expected), []byte(values["v1"]))
+}
+
+// webhookMAC computes the hex HMAC of a timestamp and body
+func webhookMAC(secret string, unix string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookStorage validates subscriptions before storing them, and keeps them
+// within the tenant that created them
+type webhookStorage struct {
+	store.CRUD
+}
+
+// Save validates and stores a new subscription, recording who created it
+func (s *webhookStorage) Save(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+	validationErr := validateSubscription(object, false)
+	if validationErr != nil {
+		return nil, validationErr
+	}
+
+	principal, _ := PrincipalFromContext(ctx)
+	tenant, _ := store.TenantFromContext(ctx)
+
+	owned, err := setOwner(object, principal, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.CRUD.Save(ctx, owned)
+}
+
+// Get reports subscriptions of another tenant as not found
+func (s *webhookStorage) Get(ctx context.Context, id string) (*jsh.Object, error) {
+	object, err := s.CRUD.Get(ctx, id)
+	if normalizeError(err) != nil {
+		return nil, err
+	}
+
+	if !sameTenant(ctx, object) {
+		return nil, jsh.NotFound(WebhookType, id)
+	}
+
+	return object, nil
+}
+
+// List leaves out subscriptions of another tenant
+func (s *webhookStorage) List(ctx context.Context) (jsh.List, error) {
+	list, err := s.CRUD.List(ctx)
+	if normalizeError(err) != nil {
+		return nil, err
+	}
+
+	scoped := jsh.List{}
+	for _, object := range list {
+		if sameTenant(ctx, object) {
+			scoped = append(scoped, object)
+		}
+	}
+
+	return scoped, nil
+}
+
+// Update validates and stores changes to a subscription, keeping its creator
+func (s *webhookStorage) Update(ctx context.Context, object *jsh.Object) (*jsh.Object, error) {
+	existing, err := s.Get(ctx, object.ID)
+	if normalizeError(err) != nil {
+		return nil, err
+	}
+
+	validationErr := validateSubscription(object, true)
+	if validationErr != nil {
+		return nil, validationErr
+	}
+
+	subscription, decodeErr := decodeSubscription(existing)
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	owned, ownerErr := setOwner(object, subscription.Principal, subscription.Tenant)
+	if ownerErr != nil {
+		return nil, ownerErr
+	}
+
+	return s.CRUD.Update(ctx, owned)
+}
+
+// Delete deletes a subscription of the request's tenant
+func (s *webhookStorage) Delete(ctx context.Context, id string) error {
+	_, err := s.Get(ctx, id)
+	if normalizeError(err) != nil {
+		return err
+	}
+
+	return s.CRUD.Delete(ctx, id)
+}
+
+// setOwner returns a copy of a subscription recording who created it
+func setOwner(object *jsh.Object, principal *Principal, tenant string) (*jsh.Object, error) {
+	owned, err := store.SetAttribute(object, "principal", principal)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.SetAttribute(owned, "tenant", tenant)
+}
+
+// sameTenant checks whether a subscription was created for the request's tenant,
+// or without a tenant if there isn't one
+func sameTenant(ctx context.Context, object *jsh.Object) bool {
+	if object == nil {
+		return false
+	}
+
+	subscription, err := decodeSubscription(object)
+	if err != nil {
+		return false
+	}
+
+	tenant, _ := store.TenantFromContext(ctx)
+	return subscription.Tenant == tenant
+}
+
+// validateSubscription checks the attributes of a subscription, only checking
+// those that are set if partial
+func validateSubscription(object *jsh.Object, partial bool) jsh.ErrorType {
+	attributes, _ := objectAttributes(object)
+
+	subscription := &WebhookSubscription{}
+	if json.Unmarshal(object.Attributes, subscription) != nil {
+		return subscriptionError("Invalid subscription", "")
+	}
+
+	invalid := jsh.ErrorList{}
+
+	if _, set := attributes["url"]; set || !partial {
+		parsed, err := url.Parse(subscription.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			invalid = append(invalid, subscriptionError("'url' must be an absolute http(s) URL", "url"))
+		}
+	}
+
+	if _, set := attributes["secret"]; (set || !partial) && subscription.Secret == "" {
+		invalid = append(invalid, subscriptionError("'secret' is required to sign deliveries", "secret"))
+	}
+
+	valid := []string{EventCreated, EventDeleted, EventUpdated}
+	for _, event := range subscription.Events {
+		index := sort.SearchStrings(valid, event)
+		if index == len(valid) || valid[index] != event {
+			invalid = append(invalid, subscriptionError(fmt.Sprintf(
+				"'events' may only contain %s", strings.Join(valid, ", "),
+			), "events"))
+			break
+		}
+	}
+
+	if len(invalid) == 0 {
+		return nil
+	}
+
+	return invalid
+}
+
+// subscriptionError creates a 422 for an invalid subscription attribute
+func subscriptionError(detail string, attribute string) *jsh.Error {
+	inputErr := jsh.InputError(detail, attribute)
+	if attribute != "" {
+		inputErr.Source.Pointer = fmt.Sprintf("/data/attributes/%s", attribute)
+	} else {
+		inputErr.Source.Pointer = "/data/attributes"
+	}
+
+	return inputErr
+}
@@ -0,0 +1,362 @@
+package jshapi
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/derekdowling/go-json-spec-handler"
+	"github.com/derekdowling/go-json-spec-handler/client"
+	"github.com/derekdowling/jsh-api/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// webhookReceiver records the deliveries it receives, failing the first failures
+type webhookReceiver struct {
+	mutex    sync.Mutex
+	failures int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	wr.bodies = append(wr.bodies, string(body))
+	wr.headers = append(wr.headers, r.Header)
+
+	if wr.failures > 0 {
+		wr.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// failingWebhookQueue can't record the outcome of any delivery
+type failingWebhookQueue struct {
+	*MemoryWebhookQueue
+}
+
+func (f *failingWebhookQueue) Update(ctx context.Context, delivery *WebhookDelivery) error {
+	return errors.New("queue is unavailable")
+}
+
+// allowAll is a policy allowing every operation
+func allowAll(ctx context.Context, principal *Principal, operation Operation, target Target) error {
+	return nil
+}
+
+func TestWebhooks(t *testing.T) {
+
+	Convey("Webhooks Tests", t, func() {
+
+		receiver := &webhookReceiver{failures: 1}
+		receiverServer := httptest.NewServer(receiver)
+		defer receiverServer.Close()
+
+		now := time.Now()
+		subscriptions := store.NewMemory(WebhookType)
+		webhooks := NewWebhooks(subscriptions, NewMemoryWebhookQueue())
+		webhooks.Now = func() time.Time { return now }
+		webhooks.Client = receiverServer.Client()
+
+		resource := webhooks.Resource()
+		resource.Authorize(allowAll)
+
+		api := New("")
+		api.Add(resource)
+		api.Add(NewCRUDResource(testResourceType, store.NewMemory(testResourceType)))
+		api.Listen(webhooks)
+
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		subscription := sampleObject("", WebhookType, map[string]interface{}{
+			"url":           receiverServer.URL,
+			"secret":        "shh",
+			"resourceTypes": []string{testResourceType},
+			"events":        []string{EventCreated},
+		})
+		doc, resp, err := jsc.Post(server.URL, subscription)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+		subscriptionID := doc.Data[0].ID
+
+		Convey("should hide subscription secrets", func() {
+			So(string(doc.Data[0].Attributes), ShouldNotContainSubstring, "shh")
+		})
+
+		Convey("should forbid every request without a policy", func() {
+			unauthorized := httptest.NewServer(webhooks.Resource())
+			defer unauthorized.Close()
+
+			_, resp, err := jsc.Post(unauthorized.URL, subscription)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("should not let clients set the owner", func() {
+			owned := sampleObject("", WebhookType, map[string]interface{}{
+				"url":    receiverServer.URL,
+				"secret": "shh",
+				"tenant": "acme",
+			})
+
+			_, resp, err := jsc.Post(server.URL, owned)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("should validate subscriptions", func() {
+			invalid := sampleObject("", WebhookType, map[string]interface{}{
+				"url":    "not a url",
+				"events": []string{"renamed"},
+			})
+
+			doc, resp, err := jsc.Post(server.URL, invalid)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+			So(doc.Errors, ShouldHaveLength, 3)
+			So(doc.Errors[0].Source.Pointer, ShouldEqual, "/data/attributes/url")
+		})
+
+		Convey("should deliver signed changes, retrying failures", func() {
+			_, resp, err := jsc.Post(server.URL, sampleObject("", testResourceType, testObjAttrs))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+
+			// updates aren't subscribed to
+			_, resp, err = jsc.Patch(server.URL, sampleObject("1", testResourceType, testObjAttrs))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			attempted, err := webhooks.Deliver(context.Background())
+			So(err, ShouldBeNil)
+			So(attempted, ShouldEqual, 1)
+
+			attempted, err = webhooks.Deliver(context.Background())
+			So(err, ShouldBeNil)
+			So(attempted, ShouldEqual, 0)
+
+			now = now.Add(WebhookBackoff(1))
+			attempted, err = webhooks.Deliver(context.Background())
+			So(err, ShouldBeNil)
+			So(attempted, ShouldEqual, 1)
+
+			So(receiver.bodies, ShouldHaveLength, 2)
+			So(receiver.bodies[1], ShouldContainSubstring, `"event":"created"`)
+			So(receiver.bodies[1], ShouldContainSubstring, `"type":"bars"`)
+
+			headers := receiver.headers[1]
+			So(headers.Get(WebhookEventHeader), ShouldEqual, EventCreated)
+			So(headers.Get(WebhookDeliveryHeader), ShouldEqual, receiver.headers[0].Get(WebhookDeliveryHeader))
+			So(VerifyWebhook("shh", headers.Get(WebhookSignatureHeader), []byte(receiver.bodies[1]), now, time.Minute), ShouldBeTrue)
+			So(VerifyWebhook("wrong", headers.Get(WebhookSignatureHeader), []byte(receiver.bodies[1]), now, time.Minute), ShouldBeFalse)
+
+			Convey("should record delivery attempts", func() {
+				deliveries, err := webhooks.Queue.List(context.Background(), subscriptionID)
+				So(err, ShouldBeNil)
+				So(deliveries, ShouldHaveLength, 1)
+				So(deliveries[0].Status, ShouldEqual, DeliveryDelivered)
+				So(deliveries[0].Attempts, ShouldHaveLength, 2)
+				So(deliveries[0].Attempts[0].StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+
+				resp, err := http.Get(server.URL + "/webhooks/" + subscriptionID + "/deliveries")
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("should give up after MaxAttempts", func() {
+			receiver.failures = 10
+			webhooks.MaxAttempts = 2
+
+			_, _, err := jsc.Post(server.URL, sampleObject("", testResourceType, testObjAttrs))
+			So(err, ShouldBeNil)
+
+			webhooks.Deliver(context.Background())
+			now = now.Add(time.Hour)
+			webhooks.Deliver(context.Background())
+
+			deliveries, err := webhooks.Queue.List(context.Background(), subscriptionID)
+			So(err, ShouldBeNil)
+			So(deliveries[0].Status, ShouldEqual, DeliveryFailed)
+		})
+
+		Convey("should fail deliveries of deleted subscriptions", func() {
+			_, _, err := jsc.Post(server.URL, sampleObject("", testResourceType, testObjAttrs))
+			So(err, ShouldBeNil)
+			So(subscriptions.Delete(context.Background(), subscriptionID), ShouldBeNil)
+
+			attempted, err := webhooks.Deliver(context.Background())
+			So(err, ShouldBeNil)
+			So(attempted, ShouldEqual, 1)
+
+			deliveries, err := webhooks.Queue.List(context.Background(), subscriptionID)
+			So(err, ShouldBeNil)
+			So(deliveries[0].Status, ShouldEqual, DeliveryFailed)
+			So(receiver.bodies, ShouldBeEmpty)
+		})
+
+		Convey("should attempt every delivery when outcomes can't be recorded", func() {
+			webhooks.Queue = &failingWebhookQueue{NewMemoryWebhookQueue()}
+
+			for i := 0; i < 2; i++ {
+				_, _, err := jsc.Post(server.URL, sampleObject("", testResourceType, testObjAttrs))
+				So(err, ShouldBeNil)
+			}
+
+			attempted, err := webhooks.Deliver(context.Background())
+			So(err, ShouldNotBeNil)
+			So(attempted, ShouldEqual, 2)
+			So(receiver.bodies, ShouldHaveLength, 2)
+		})
+
+		Convey("should refuse to deliver to private addresses by default", func() {
+			webhooks.Client = NewWebhookClient(time.Second)
+
+			_, _, err := jsc.Post(server.URL, sampleObject("", testResourceType, testObjAttrs))
+			So(err, ShouldBeNil)
+
+			_, err = webhooks.Deliver(context.Background())
+			So(err, ShouldBeNil)
+
+			deliveries, err := webhooks.Queue.List(context.Background(), subscriptionID)
+			So(err, ShouldBeNil)
+			So(deliveries[0].Attempts[0].Error, ShouldContainSubstring, "non-public address")
+			So(receiver.bodies, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestWebhookOwners(t *testing.T) {
+
+	Convey("Webhook Owner Tests", t, func() {
+
+		receiver := &webhookReceiver{}
+		receiverServer := httptest.NewServer(receiver)
+		defer receiverServer.Close()
+
+		webhooks := NewWebhooks(store.NewMemory(WebhookType), NewMemoryWebhookQueue())
+		webhooks.Client = receiverServer.Client()
+
+		subscriptions := webhooks.Resource()
+		subscriptions.Authorize(allowAll)
+
+		secrets := NewCRUDResource(testResourceType, store.NewMemory(testResourceType))
+		secrets.TenantScoped("tenant")
+		secrets.AttributePolicy("foo", AttributePolicy{
+			Read: func(ctx context.Context, principal *Principal, object *jsh.Object) bool {
+				return principal != nil && principal.HasRole("admin")
+			},
+		})
+
+		api := New("")
+		api.Use(NewTenancy(TenantFromHeader("X-Tenant")).Middleware)
+		api.Add(subscriptions)
+		api.Add(secrets)
+		api.Listen(webhooks)
+
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		post := func(tenant string, object *jsh.Object) (*jsh.Document, *http.Response) {
+			request, err := jsc.PostRequest(server.URL, object)
+			So(err, ShouldBeNil)
+			request.Header.Set("X-Tenant", tenant)
+
+			doc, resp, err := jsc.Do(request, jsh.ObjectMode)
+			So(err, ShouldBeNil)
+			return doc, resp
+		}
+
+		doc, resp := post("acme", sampleObject("", WebhookType, map[string]interface{}{
+			"url":           receiverServer.URL,
+			"secret":        "shh",
+			"resourceTypes": []string{testResourceType},
+		}))
+		So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+		subscriptionID := doc.Data[0].ID
+
+		Convey("should hide subscriptions from other tenants", func() {
+			request, err := jsc.FetchRequest(server.URL, WebhookType, subscriptionID)
+			So(err, ShouldBeNil)
+			request.Header.Set("X-Tenant", "globex")
+
+			_, resp, err := jsc.Do(request, jsh.ObjectMode)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("should only deliver changes the creator may see", func() {
+			_, resp := post("globex", sampleObject("", testResourceType, testObjAttrs))
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+
+			_, resp = post("acme", sampleObject("", testResourceType, testObjAttrs))
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+
+			deliveries, err := webhooks.Queue.List(context.Background(), subscriptionID)
+			So(err, ShouldBeNil)
+			So(deliveries, ShouldHaveLength, 1)
+			So(string(deliveries[0].Payload), ShouldContainSubstring, `"tenant":"acme"`)
+			So(string(deliveries[0].Payload), ShouldNotContainSubstring, `"foo"`)
+		})
+	})
+}
+
+func TestFileWebhookQueue(t *testing.T) {
+
+	Convey("FileWebhookQueue Tests", t, func() {
+
+		dir, err := ioutil.TempDir("", "webhooks")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "queue.json")
+		queue, err := NewFileWebhookQueue(path)
+		So(err, ShouldBeNil)
+
+		now := time.Now()
+		delivery := &WebhookDelivery{
+			ID:             "1",
+			SubscriptionID: "a",
+			Payload:        []byte(`{"data":null}`),
+			Status:         DeliveryPending,
+			NextAttempt:    now,
+		}
+		So(queue.Push(context.Background(), delivery), ShouldBeNil)
+
+		Convey("should reload queued deliveries", func() {
+			reloaded, err := NewFileWebhookQueue(path)
+			So(err, ShouldBeNil)
+
+			due, err := reloaded.Due(context.Background(), now)
+			So(err, ShouldBeNil)
+			So(due, ShouldHaveLength, 1)
+			So(string(due[0].Payload), ShouldEqual, `{"data":null}`)
+		})
+
+		Convey("should persist updates", func() {
+			delivery.Status = DeliveryDelivered
+			So(queue.Update(context.Background(), delivery), ShouldBeNil)
+
+			reloaded, err := NewFileWebhookQueue(path)
+			So(err, ShouldBeNil)
+
+			due, err := reloaded.Due(context.Background(), now)
+			So(err, ShouldBeNil)
+			So(due, ShouldBeEmpty)
+		})
+	})
+}